@@ -0,0 +1,261 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const cgoSrcDir = "${SRCDIR}"
+
+// cgoSourceSuffixes are the suffixes of the files that can contain #cgo
+// directives or #include lines.
+var cgoSourceSuffixes = []string{".go", ".c", ".cc", ".cpp", ".cxx", ".h", ".hh", ".hpp", ".hxx", ".m", ".s", ".S", ".swig", ".swigcxx"}
+
+// cgoLibSuffixes are the suffixes of the libraries kept from the library
+// directories.
+var cgoLibSuffixes = []string{".a", ".so"}
+
+// cgoSource is a file to scan for #include lines with the include
+// directories of the package that references it.
+type cgoSource struct {
+	path        string
+	includeDirs []string
+}
+
+// cgoAssets returns the files referenced by the #cgo directives and the
+// #include lines of the provided files. Included files are scanned too, so
+// their own includes are also returned. Include directories referenced with
+// ${SRCDIR} are only used to resolve the #include lines and only the
+// libraries inside the library directories are returned.
+func cgoAssets(files []string) ([]string, error) {
+	var (
+		assets  []string
+		sources []cgoSource
+	)
+	seen := map[string]struct{}{}
+	for _, file := range files {
+		seen[file] = struct{}{}
+	}
+	// addAsset adds a new asset and queues it for #include lines scanning
+	addAsset := func(path string, includeDirs []string) {
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		assets = append(assets, path)
+		if hasSuffix(path, cgoSourceSuffixes) {
+			sources = append(sources, cgoSource{path, includeDirs})
+		}
+	}
+
+	// Parse the #cgo directives of the go files
+	includeDirs := map[string][]string{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		pkgDir := filepath.Dir(file)
+		incDirs, paths, err := cgoDirectivePaths(file)
+		if err != nil {
+			return nil, err
+		}
+		includeDirs[pkgDir] = append(includeDirs[pkgDir], incDirs...)
+		for _, path := range paths {
+			pathFiles, err := cgoPathFiles(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range pathFiles {
+				addAsset(f, incDirs)
+			}
+		}
+	}
+
+	for _, file := range files {
+		if hasSuffix(file, cgoSourceSuffixes) {
+			sources = append(sources, cgoSource{file, includeDirs[filepath.Dir(file)]})
+		}
+	}
+
+	// Resolve the #include lines
+	for len(sources) > 0 {
+		source := sources[0]
+		sources = sources[1:]
+
+		includes, err := cgoIncludes(source.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, include := range includes {
+			path := resolveInclude(filepath.Dir(source.path), include, source.includeDirs)
+			if path == "" {
+				continue
+			}
+			addAsset(path, source.includeDirs)
+		}
+	}
+
+	return assets, nil
+}
+
+// cgoDirectivePaths returns the include directories and the other paths
+// (libraries and library directories) referenced using ${SRCDIR} by the
+// #cgo directives of the provided go file.
+func cgoDirectivePaths(file string) ([]string, []string, error) {
+	var includeDirs, paths []string
+
+	lines, err := cgoLines(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	srcDir := filepath.Dir(file)
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#cgo ") && !strings.HasPrefix(line, "#cgo\t") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		var prev string
+		for _, arg := range strings.Fields(line[i+1:]) {
+			flag := prev
+			prev = arg
+			if !strings.Contains(arg, cgoSrcDir) {
+				continue
+			}
+			for _, f := range []string{"-I", "-L", "-iquote", "-isystem", "-idirafter"} {
+				if strings.HasPrefix(arg, f) {
+					flag, arg = f, strings.TrimPrefix(arg, f)
+					break
+				}
+			}
+			path := filepath.Clean(strings.Replace(arg, cgoSrcDir, srcDir, -1))
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			switch flag {
+			case "-I", "-iquote", "-isystem", "-idirafter":
+				includeDirs = append(includeDirs, path)
+			default:
+				paths = append(paths, path)
+			}
+		}
+	}
+	return includeDirs, paths, nil
+}
+
+// cgoPathFiles returns the files referenced by a #cgo directive path: the
+// path itself if it's a file or the libraries inside it if it's a library
+// directory.
+func cgoPathFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	names, err := readDirNames(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var files []string
+	for _, name := range names {
+		file := filepath.Join(path, name)
+		if fi, err := os.Stat(file); err == nil && !fi.IsDir() && hasSuffix(name, cgoLibSuffixes) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// cgoIncludes returns the files included with #include or #import lines.
+func cgoIncludes(file string) ([]string, error) {
+	var includes []string
+
+	lines, err := cgoLines(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		var rest string
+		switch {
+		case strings.HasPrefix(line, "#include"):
+			rest = strings.TrimSpace(strings.TrimPrefix(line, "#include"))
+		case strings.HasPrefix(line, "#import"):
+			rest = strings.TrimSpace(strings.TrimPrefix(line, "#import"))
+		default:
+			continue
+		}
+		if len(rest) < 2 {
+			continue
+		}
+		end := '"'
+		if rest[0] == '<' {
+			end = '>'
+		} else if rest[0] != '"' {
+			continue
+		}
+		if i := strings.IndexRune(rest[1:], end); i > 0 {
+			includes = append(includes, rest[1:i+1])
+		}
+	}
+	return includes, nil
+}
+
+// cgoLines returns the trimmed lines of a file, removing the go comment
+// markers that enclose the cgo preamble.
+func cgoLines(file string) ([]string, error) {
+	var lines []string
+	err := scanLines(file, func(line string) error {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		line = strings.TrimSpace(strings.TrimPrefix(line, "/*"))
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, err
+}
+
+// resolveInclude returns the path of an included file searching it in the
+// including file directory and then in the include directories. It returns
+// an empty string if the file cannot be found.
+func resolveInclude(dir, include string, includeDirs []string) string {
+	include = filepath.FromSlash(include)
+	for _, d := range append([]string{dir}, includeDirs...) {
+		path := filepath.Join(d, include)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// walkFiles returns all the files inside path. If path is a file it's
+// returned as is.
+func walkFiles(path string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func hasSuffix(path string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
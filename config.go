@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFile is the name of the optional glide-vc configuration file searched
+// in the project root.
+const configFile = "glide-vc.yaml"
+
+// config is the glide-vc configuration file content.
+type config struct {
+	// Packages contains per dependency overrides keyed by import path
+	// prefix. When more prefixes match a package the longest one is used.
+	Packages map[string]*packageConfig `yaml:"packages"`
+
+	// Tools are vendored packages, not imported by the project, that must
+	// be kept with all their imports (i.e. to go install them from the
+	// vendor dir)
+	Tools []string `yaml:"tools"`
+
+	// KeepRepos are the import paths of the dependencies that must be kept
+	// entirely, at every vendor level
+	KeepRepos []string `yaml:"keepRepos"`
+
+	// CodeSuffixes are the suffixes of the source code files
+	CodeSuffixes listConfig `yaml:"codeSuffixes"`
+	// LicenseFilePrefixes are the filename prefixes of license files
+	LicenseFilePrefixes listConfig `yaml:"licenseFilePrefixes"`
+	// LegalFileSubstrings are the filename substrings of legal files
+	LegalFileSubstrings listConfig `yaml:"legalFileSubstrings"`
+	// LegalFilePatterns are regular expressions matching the filename of
+	// additional legal files
+	LegalFilePatterns []string `yaml:"legalFilePatterns"`
+
+	codeSuffixes        []string
+	licenseFilePrefixes []string
+	legalFileSubstrings []string
+	legalFileRegexps    []*regexp.Regexp
+}
+
+// listConfig changes a default list of values.
+type listConfig struct {
+	// Replace, when defined, replaces the default values
+	Replace []string `yaml:"replace"`
+	// Extend adds values to the default (or replaced) ones
+	Extend []string `yaml:"extend"`
+}
+
+func (l listConfig) apply(values []string) []string {
+	if l.Replace != nil {
+		values = l.Replace
+	}
+	return append(append([]string{}, values...), l.Extend...)
+}
+
+// packageConfig overrides the global options for the packages matching its
+// import path prefix. Unset fields keep the global value.
+type packageConfig struct {
+	OnlyCode     *bool `yaml:"onlyCode"`
+	NoTests      *bool `yaml:"noTests"`
+	NoLegalFiles *bool `yaml:"noLegalFiles"`
+	// Keep are patterns of additional files to keep. The pattern match will
+	// be relative to the dependency prefix. A leading ! excludes the files
+	// matched by the previous patterns.
+	Keep []string `yaml:"keep"`
+
+	keepRules keepRules
+}
+
+// readConfig reads the configuration file. If file is empty the default
+// configFile inside the project path is used, if it exists.
+func readConfig(path, file string) (*config, error) {
+	conf := &config{}
+
+	if file == "" {
+		file = filepath.Join(path, configFile)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return conf, conf.setup()
+		}
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", file, err)
+	}
+	if err := conf.setup(); err != nil {
+		for _, pe := range err.(patternErrors) {
+			pe.source = file + ": " + pe.source
+		}
+		return nil, err
+	}
+
+	// Convert the prefixes to the os specific path separator, needed for
+	// future comparisons.
+	packages := map[string]*packageConfig{}
+	for prefix, pc := range conf.Packages {
+		if pc == nil {
+			pc = &packageConfig{}
+		}
+		prefix = filepath.FromSlash(prefix)
+		pc.keepRules = scopedKeepRules(prefix, pc.Keep)
+		packages[prefix] = pc
+	}
+	conf.Packages = packages
+
+	return conf, nil
+}
+
+// setup computes the file detection tables from the defaults and the
+// configuration and validates the patterns. It returns all the invalid
+// patterns as patternErrors.
+func (c *config) setup() error {
+	c.codeSuffixes = c.CodeSuffixes.apply(codeSuffixes)
+	c.licenseFilePrefixes = c.LicenseFilePrefixes.apply(LicenseFilePrefix)
+	c.legalFileSubstrings = c.LegalFileSubstrings.apply(LegalFileSubstring)
+
+	var errs patternErrors
+	for _, pattern := range c.LegalFilePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, &patternError{source: "legalFilePatterns", pattern: pattern, err: err})
+			continue
+		}
+		c.legalFileRegexps = append(c.legalFileRegexps, re)
+	}
+	var prefixes []string
+	for prefix := range c.Packages {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if pc := c.Packages[prefix]; pc != nil {
+			errs = append(errs, scopedKeepRules(prefix, pc.Keep).check(fmt.Sprintf("packages: %s: keep", prefix))...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// isCodeFile returns true if the file is a source code file
+func (c *config) isCodeFile(path string) bool {
+	return hasSuffix(path, c.codeSuffixes)
+}
+
+// isLegalFile returns true if the file is likely to contain some type of
+// legal declaration or licensing information
+func (c *config) isLegalFile(path string) bool {
+	if isLegalFile(path, c.licenseFilePrefixes, c.legalFileSubstrings) {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, re := range c.legalFileRegexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// override returns the dependency prefix and the package config with the
+// longest prefix matching the provided path. It returns a nil packageConfig
+// if no override is defined.
+func (c *config) override(path string) (string, *packageConfig) {
+	var (
+		prefix string
+		pc     *packageConfig
+	)
+	for p, cur := range c.Packages {
+		if isParentDirectory(p, path) && len(p) > len(prefix) {
+			prefix, pc = p, cur
+		}
+	}
+	return prefix, pc
+}
+
+// anyNoTests returns true if the test files of some dependencies are removed
+// with the provided options.
+func (c *config) anyNoTests(o options) bool {
+	if o.noTests {
+		return true
+	}
+	for _, pc := range c.Packages {
+		if pc != nil && pc.NoTests != nil && *pc.NoTests {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the provided options with the package overrides applied.
+func (pc *packageConfig) apply(o options) options {
+	if pc == nil {
+		return o
+	}
+	if pc.OnlyCode != nil {
+		o.onlyCode = *pc.OnlyCode
+	}
+	if pc.NoTests != nil {
+		o.noTests = *pc.NoTests
+	}
+	if pc.NoLegalFiles != nil {
+		o.noLegalFiles = *pc.NoLegalFiles
+	}
+	return o
+}
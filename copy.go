@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// Methods used to copy a file
+const (
+	copyHardlink = "hardlinked"
+	copyReflink  = "reflinked"
+	copyCopy     = "copied"
+)
+
+// copyKept copies the kept vendor files to outputDir, that must not exist or
+// be empty, instead of removing the unused ones from the vendor dir. The
+// files are hard linked or, when not possible (like across file systems),
+// reflinked or copied.
+func copyKept(w io.Writer, plan *cleanupPlan, outputDir string) error {
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	if isParentDirectory(plan.vpath, outputDir) || isParentDirectory(outputDir, plan.vpath) {
+		return fmt.Errorf("output dir %s cannot contain or be inside the vendor dir %s", outputDir, plan.vpath)
+	}
+	if empty, err := gpath.IsDirectoryEmpty(outputDir); err == nil && !empty {
+		return fmt.Errorf("output dir %s is not empty", outputDir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// The directories containing unused paths
+	partial := map[string]struct{}{}
+	for _, marked := range plan.markForDelete {
+		localPath := strings.TrimPrefix(marked.path, plan.searchPath)
+		if opts.format == formatList || opts.format == "" {
+			if marked.isDir {
+				fmt.Fprintf(w, "Skipping unused dir: %s\n", localPath)
+			} else {
+				fmt.Fprintf(w, "Skipping unused file: %s\n", localPath)
+			}
+		}
+		for curpath := filepath.Dir(localPath); curpath != "."; curpath = filepath.Dir(curpath) {
+			partial[curpath] = struct{}{}
+		}
+	}
+	if opts.dryrun {
+		return nil
+	}
+
+	var entries []pathData
+	for _, e := range plan.markForKeep {
+		entries = append(entries, e)
+	}
+	// Parent directories come before their contents
+	sort.Sort(byArchiveName(entries))
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	// the last directory copied entirely
+	copiedDir := ""
+	for _, e := range entries {
+		if copiedDir != "" && isParentDirectory(copiedDir, e.path) {
+			continue
+		}
+		src := filepath.Join(plan.vpath, e.path)
+		dst := filepath.Join(outputDir, e.path)
+		if !e.isDir {
+			method, err := copyKeptFile(src, dst)
+			if err != nil {
+				return err
+			}
+			counts[method]++
+			continue
+		}
+		// When files cannot be linked copy the directories without unused
+		// paths at once
+		if _, ok := partial[e.path]; !ok && counts[copyCopy] > 0 {
+			files, _, err := diskUsage(src)
+			if err != nil {
+				return err
+			}
+			if err := gpath.CopyDir(src, dst); err != nil {
+				return err
+			}
+			counts[copyCopy] += files
+			copiedDir = e.path
+			continue
+		}
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Copied %d files to %s (%d hardlinked, %d reflinked, %d copied)\n",
+		counts[copyHardlink]+counts[copyReflink]+counts[copyCopy], outputDir,
+		counts[copyHardlink], counts[copyReflink], counts[copyCopy])
+	return nil
+}
+
+// copyKeptFile hard links, reflinks or copies src to dst and returns the
+// used method.
+func copyKeptFile(src, dst string) (string, error) {
+	if err := os.Link(src, dst); err == nil {
+		return copyHardlink, nil
+	}
+	if err := reflink(src, dst); err == nil {
+		return copyReflink, nil
+	}
+	if err := gpath.CopyFile(src, dst); err != nil {
+		return "", err
+	}
+	return copyCopy, nil
+}
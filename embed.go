@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	goEmbedDirective = "//go:embed"
+	goEmbedAllPrefix = "all:"
+)
+
+// embedPatterns returns the patterns of the //go:embed directives inside the
+// provided go file.
+func embedPatterns(path string) ([]string, error) {
+	var patterns []string
+	err := scanLines(path, func(line string) error {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, goEmbedDirective) {
+			return nil
+		}
+		args := strings.TrimPrefix(line, goEmbedDirective)
+		// Ignore directives like //go:embedfoo
+		if args != "" && args[0] != ' ' && args[0] != '\t' {
+			return nil
+		}
+		p, err := splitEmbedPatterns(args)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		patterns = append(patterns, p...)
+		return nil
+	})
+	return patterns, err
+}
+
+// scanLines calls fn for every line of file. Unlike bufio.Scanner it doesn't
+// limit the line length, so files with very long lines (like go-bindata
+// output) can be read.
+func scanLines(file string, fn func(line string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			if err := fn(strings.TrimSuffix(line, "\n")); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+// splitEmbedPatterns splits the arguments of a //go:embed directive. Patterns
+// are space separated and can be quoted using go string syntax.
+func splitEmbedPatterns(args string) ([]string, error) {
+	var patterns []string
+	for {
+		args = strings.TrimLeft(args, " \t")
+		if args == "" {
+			return patterns, nil
+		}
+
+		var pattern string
+		switch args[0] {
+		case '"':
+			i := 1
+			for ; i < len(args) && args[i] != '"'; i++ {
+				if args[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(args) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			unquoted, err := strconv.Unquote(args[:i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			pattern, args = unquoted, args[i+1:]
+		case '`':
+			i := strings.IndexByte(args[1:], '`')
+			if i < 0 {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			pattern, args = args[1:i+1], args[i+2:]
+		default:
+			i := strings.IndexAny(args, " \t")
+			if i < 0 {
+				i = len(args)
+			}
+			pattern, args = args[:i], args[i:]
+		}
+		patterns = append(patterns, pattern)
+	}
+}
+
+// embedFiles returns the files matched by an embed pattern relative to dir.
+// Like the go tool, when a pattern matches a directory all the files inside
+// it are returned, excluding the ones starting with '.' or '_' unless the
+// pattern has the "all:" prefix.
+func embedFiles(dir, pattern string) ([]string, error) {
+	all := strings.HasPrefix(pattern, goEmbedAllPrefix)
+	pattern = strings.TrimPrefix(pattern, goEmbedAllPrefix)
+
+	matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+	if err != nil {
+		return nil, fmt.Errorf("bad embed pattern: %q", pattern)
+	}
+
+	var files []string
+	for _, match := range matches {
+		err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != match && !all {
+				if name := info.Name(); strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes
+const (
+	exitError         = 1
+	exitUsage         = 2
+	exitResolver      = 3
+	exitVendorDir     = 4
+	exitPattern       = 5
+	exitPermission    = 6
+	exitPartialDelete = 7
+)
+
+// usageError is an invalid command line
+type usageError struct {
+	msg string
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// resolverError is a failure of the imports resolver
+type resolverError struct {
+	resolver string
+	err      error
+}
+
+func (e *resolverError) Error() string {
+	return fmt.Sprintf("cannot resolve the imports using %s: %v", e.resolver, e.err)
+}
+
+// vendorDirError is a missing vendor dir or a vendor dir not matching the
+// project
+type vendorDirError struct {
+	msg string
+}
+
+func vendorDirErrorf(format string, a ...interface{}) error {
+	return &vendorDirError{msg: fmt.Sprintf(format, a...)}
+}
+
+func (e *vendorDirError) Error() string { return e.msg }
+
+// patternError is an invalid pattern
+type patternError struct {
+	// where the pattern comes from (a file or an option)
+	source  string
+	pattern string
+	err     error
+}
+
+func (e *patternError) Error() string {
+	msg := fmt.Sprintf("bad pattern: %q", e.pattern)
+	if e.source != "" {
+		msg = e.source + ": " + msg
+	}
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+// patternErrors are all the invalid patterns found
+type patternErrors []*patternError
+
+func (e patternErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := []string{fmt.Sprintf("%d invalid patterns:", len(e))}
+	for _, pe := range e {
+		msgs = append(msgs, "- "+pe.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// deleteError reports the paths, relative to the vendor dir, that were and
+// were not removed when some removals failed.
+type deleteError struct {
+	removed    []string
+	notRemoved []string
+	// the removal error of every not removed path
+	errs map[string]error
+}
+
+func (e *deleteError) Error() string {
+	var b bytes.Buffer
+	total := len(e.removed) + len(e.notRemoved)
+	if len(e.removed) > 0 {
+		fmt.Fprintf(&b, "partial delete: removed %d of %d paths\n", len(e.removed), total)
+	} else {
+		fmt.Fprintf(&b, "delete failed: removed 0 of %d paths\n", total)
+	}
+	fmt.Fprintf(&b, "Not removed:\n")
+	for _, p := range e.notRemoved {
+		fmt.Fprintf(&b, "- %s: %v\n", filepath.ToSlash(p), e.errs[p])
+	}
+	if len(e.removed) > 0 {
+		fmt.Fprintf(&b, "Removed:\n")
+		for _, p := range e.removed {
+			fmt.Fprintf(&b, "- %s\n", filepath.ToSlash(p))
+		}
+	}
+	return b.String()[:b.Len()-1]
+}
+
+// permissionDenied returns true if all the removals failed due to missing
+// permissions
+func (e *deleteError) permissionDenied() bool {
+	for _, err := range e.errs {
+		if !os.IsPermission(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// exitCode returns the exit code for an error
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case *usageError:
+		return exitUsage
+	case *resolverError:
+		return exitResolver
+	case *vendorDirError:
+		return exitVendorDir
+	case *patternError, patternErrors:
+		return exitPattern
+	case *deleteError:
+		if len(e.removed) > 0 {
+			return exitPartialDelete
+		}
+		if e.permissionDenied() {
+			return exitPermission
+		}
+	}
+	return exitError
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	gpath "github.com/Masterminds/glide/path"
+	"github.com/spf13/cobra"
+)
+
+// Archive formats
+const (
+	archiveTarGz = "tar.gz"
+	archiveZip   = "zip"
+)
+
+// exportModTime is the modification time of all the archive entries. It's
+// the zip minimum date.
+var exportModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [project dir]",
+	Short: "write the files that will be kept by the cleanup to an archive without changing the vendor dir",
+	Run:   export,
+}
+
+var exportOpts struct {
+	format string
+	output string
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportOpts.format, "format", archiveTarGz, "archive format: tar.gz or zip")
+	exportCmd.Flags().StringVarP(&exportOpts.output, "output", "o", "", "the archive file")
+	cmd.AddCommand(exportCmd)
+}
+
+func export(cmd *cobra.Command, args []string) {
+	if err := validateOptions(); err != nil {
+		fatal(err)
+	}
+	switch exportOpts.format {
+	case archiveTarGz, archiveZip:
+	default:
+		fatal(usageErrorf("unknown archive format %q", exportOpts.format))
+	}
+	if exportOpts.output == "" {
+		fatal(usageErrorf("the archive file must be provided with --output"))
+	}
+
+	root, err := projectRoot(args)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := exportVendor(stdout(), root, exportOpts.output, exportOpts.format); err != nil {
+		fatal(err)
+	}
+}
+
+// exportVendor writes the vendor files that will be kept by the cleanup to
+// an archive. The archive content only depends on the kept files contents
+// and their executable bit: the entries are sorted and have fixed
+// modification time, owner and permissions.
+func exportVendor(w io.Writer, path, output, format string) (err error) {
+	plan, err := planCleanup(w, path)
+	if err != nil {
+		return err
+	}
+
+	var entries []pathData
+	for _, e := range plan.markForKeep {
+		entries = append(entries, e)
+	}
+	sort.Sort(byArchiveName(entries))
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+
+	if format == archiveZip {
+		err = writeZip(f, plan.vpath, entries)
+	} else {
+		err = writeTarGz(f, plan.vpath, entries)
+	}
+	if err != nil {
+		return err
+	}
+
+	files := 0
+	for _, e := range entries {
+		if !e.isDir {
+			files++
+		}
+	}
+	fmt.Fprintf(w, "Exported %d files to %s\n", files, output)
+	return nil
+}
+
+// byArchiveName sorts the entries by their archive name. Parent directories
+// always come before their contents.
+type byArchiveName []pathData
+
+func (s byArchiveName) Len() int           { return len(s) }
+func (s byArchiveName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byArchiveName) Less(i, j int) bool { return archiveName(s[i]) < archiveName(s[j]) }
+
+// archiveName returns the name of the entry inside the archive. The entries
+// are inside a vendor directory.
+func archiveName(e pathData) string {
+	name := gpath.VendorDir + "/" + filepath.ToSlash(e.path)
+	if e.isDir {
+		name += "/"
+	}
+	return name
+}
+
+// archiveMode returns the fixed permissions of an archive entry
+func archiveMode(e pathData, fi os.FileInfo) os.FileMode {
+	switch {
+	case e.isDir:
+		return os.ModeDir | 0755
+	case fi.Mode()&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case fi.Mode()&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// entryInfo returns the file info of an archive entry, without following
+// the symlinks, and the target of a symlink. The directories are the followed
+// symlinks too.
+func entryInfo(file string) (os.FileInfo, string, error) {
+	fi, err := os.Lstat(file)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return fi, "", err
+	}
+	target, err := os.Readlink(file)
+	return fi, target, err
+}
+
+func writeTarGz(w io.Writer, vpath string, entries []pathData) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		file := filepath.Join(vpath, e.path)
+		fi, target, err := entryInfo(file)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    archiveName(e),
+			Mode:    int64(archiveMode(e, fi).Perm()),
+			ModTime: exportModTime,
+		}
+		switch {
+		case e.isDir:
+			hdr.Typeflag = tar.TypeDir
+		case target != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = fi.Size()
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !e.isDir && target == "" {
+			if err := copyFile(tw, file); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeZip(w io.Writer, vpath string, entries []pathData) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		file := filepath.Join(vpath, e.path)
+		fi, target, err := entryInfo(file)
+		if err != nil {
+			return err
+		}
+		hdr := &zip.FileHeader{
+			Name:   archiveName(e),
+			Method: zip.Deflate,
+		}
+		if e.isDir {
+			hdr.Method = zip.Store
+		}
+		hdr.SetModTime(exportModTime)
+		hdr.SetMode(archiveMode(e, fi))
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.isDir:
+		case target != "":
+			// Like zip does, the symlink target is the entry content
+			if _, err := io.WriteString(fw, target); err != nil {
+				return err
+			}
+		default:
+			if err := copyFile(fw, file); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const goGenerateDirective = "//go:generate"
+
+// idlSuffixes are the suffixes of the interface definition and grammar files
+// used to generate go code.
+var idlSuffixes = []string{".proto", ".fbs", ".thrift", ".y"}
+
+// generateSources returns the files needed to regenerate the code of the
+// provided files: the files referenced by the //go:generate directives of the
+// go files and the idl files inside the directories of generated go files.
+func generateSources(files []string) ([]string, error) {
+	var sources []string
+	seen := map[string]struct{}{}
+	for _, file := range files {
+		seen[file] = struct{}{}
+	}
+	addSource := func(path string) {
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			sources = append(sources, path)
+		}
+	}
+
+	generatedDirs := map[string]struct{}{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		dir := filepath.Dir(file)
+
+		args, generated, err := goGenerateArgs(file)
+		if err != nil {
+			return nil, err
+		}
+		if generated || strings.HasSuffix(file, ".pb.go") {
+			generatedDirs[dir] = struct{}{}
+		}
+
+		for _, arg := range args {
+			for _, path := range generateArgPaths(dir, arg) {
+				fi, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				// Ignore the package directory and its parents (like in "--go_out=.")
+				if fi.IsDir() && isParentDirectory(path, dir) {
+					continue
+				}
+				dirFiles, err := walkFiles(path)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range dirFiles {
+					addSource(f)
+				}
+			}
+		}
+	}
+
+	// Keep the idl files in the directories of generated files
+	for dir := range generatedDirs {
+		entries, err := readDirNames(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range entries {
+			if hasSuffix(name, idlSuffixes) {
+				addSource(filepath.Join(dir, name))
+			}
+		}
+	}
+
+	return sources, nil
+}
+
+// goGenerateArgs returns the arguments of the //go:generate directives of a
+// go file, with $GOFILE expanded, and if the file has a "Code generated"
+// header comment.
+func goGenerateArgs(file string) ([]string, bool, error) {
+	var (
+		args      []string
+		generated bool
+	)
+	err := scanLines(file, func(line string) error {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "// Code generated ") && strings.HasSuffix(line, "DO NOT EDIT.") {
+			generated = true
+		}
+		if !strings.HasPrefix(line, goGenerateDirective+" ") && !strings.HasPrefix(line, goGenerateDirective+"\t") {
+			return nil
+		}
+		line = strings.Replace(line, "$GOFILE", filepath.Base(file), -1)
+		for _, arg := range strings.Fields(strings.TrimPrefix(line, goGenerateDirective)) {
+			args = append(args, strings.Trim(arg, "\"'`"))
+		}
+		return nil
+	})
+	return args, generated, err
+}
+
+// generateArgPaths returns the paths, relative to dir, that a go generate
+// command argument could reference. Option values (like in
+// "--proto_path=../proto") and glob patterns are also considered.
+func generateArgPaths(dir, arg string) []string {
+	candidates := []string{arg}
+	if i := strings.Index(arg, "="); i >= 0 {
+		candidates = append(candidates, arg[i+1:])
+	}
+
+	var paths []string
+	for _, c := range candidates {
+		if c == "" || strings.HasPrefix(c, "-") || filepath.IsAbs(c) {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(c))
+		matches, err := filepath.Glob(path)
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// readDirNames returns the names of the entries of a directory
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// gitMaxArgs is the maximum number of paths passed to a single git command
+const gitMaxArgs = 100
+
+// git runs a git command inside dir returning its output.
+func git(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitCheck verifies that path is inside a git work tree and that the vendor
+// dir, and the lock file when lock is true, don't have unstaged changes or
+// untracked files, so only the removals will be staged. When commit is true it
+// also verifies that there aren't staged changes that will end up in the
+// commit.
+func gitCheck(path, vpath string, lock, commit bool) error {
+	if _, err := git(path, nil, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return fmt.Errorf("%s is not a git repository: %v", path, err)
+	}
+
+	checkClean := func(p, name string) error {
+		out, err := git(path, nil, "status", "--porcelain", "--untracked-files=all", "--", p)
+		if err != nil {
+			return err
+		}
+		for _, line := range splitLines(string(out)) {
+			// the second column is the work tree status
+			if len(line) > 1 && line[1] != ' ' {
+				return fmt.Errorf("%s has unstaged changes or untracked files, commit or stash them before running glide-vc:\n%s", name, out)
+			}
+		}
+		return nil
+	}
+	if err := checkClean(vpath, "vendor dir"); err != nil {
+		return err
+	}
+	if lock {
+		if err := checkClean(filepath.Join(path, gpath.LockFile), gpath.LockFile); err != nil {
+			return err
+		}
+	}
+
+	if commit {
+		if _, err := git(path, nil, "diff", "--cached", "--quiet"); err != nil {
+			return fmt.Errorf("the git index has staged changes, commit or unstage them before running glide-vc")
+		}
+	}
+	return nil
+}
+
+// gitStage stages the removal of the provided paths.
+func gitStage(path string, removed []string) error {
+	for len(removed) > 0 {
+		n := len(removed)
+		if n > gitMaxArgs {
+			n = gitMaxArgs
+		}
+		args := append([]string{"rm", "-r", "--cached", "--quiet", "--ignore-unmatch", "--"}, removed[:n]...)
+		if _, err := git(path, nil, args...); err != nil {
+			return err
+		}
+		removed = removed[n:]
+	}
+	return nil
+}
+
+// gitAdd stages the changes of the provided files.
+func gitAdd(path string, files ...string) error {
+	_, err := git(path, nil, append([]string{"add", "--"}, files...)...)
+	return err
+}
+
+// gitCommit commits the staged removals. The commit message body lists the
+// removed directories and the saved space.
+func gitCommit(path, message string, removedDirs []string, files int, size int64) error {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s\n\n", message)
+	if len(removedDirs) > 0 {
+		fmt.Fprintf(&body, "Removed directories:\n")
+		for _, dir := range removedDirs {
+			fmt.Fprintf(&body, "- %s\n", filepath.ToSlash(dir))
+		}
+		fmt.Fprintf(&body, "\n")
+	}
+	fmt.Fprintf(&body, "Removed %d files, %d bytes saved.\n", files, size)
+
+	if _, err := git(path, body.Bytes(), "commit", "--quiet", "--file", "-"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// diskUsage returns the number of files and their total size under path.
+func diskUsage(path string) (int, int64, error) {
+	var (
+		files int
+		size  int64
+	)
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size, err
+}
@@ -3,30 +3,54 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/Masterminds/glide/cfg"
 	gpath "github.com/Masterminds/glide/path"
-	"github.com/bmatcuk/doublestar"
 	"github.com/spf13/cobra"
 )
 
 var cmd = &cobra.Command{
-	Use:   "glide-vc",
+	Use:   "glide-vc [project dir]",
 	Short: "glide vendor cleaner",
-	Run:   glidevc,
+	// When run as a glide plugin use the glide environment
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := setupLog(); err != nil {
+			fatal(err)
+		}
+		glideEnv()
+	},
+	Run: glidevc,
 }
 
 type options struct {
-	dryrun       bool
-	onlyCode     bool
-	noTests      bool
-	noLegalFiles bool
-	keepPatterns []string
+	dryrun         bool
+	format         string
+	depth          int
+	onlyCode       bool
+	noTests        bool
+	noLegalFiles   bool
+	keepPatterns   []string
+	keepRepos      []string
+	configFile     string
+	vendorDir      string
+	followSymlinks bool
+	recursive      string
+	outputDir      string
+
+	keepGenerateSources bool
+	syncLock            bool
+	gitStage            bool
+	gitCommit           string
+
+	useImportGraph bool
+	testImports    string
 
 	// Deprecated
 	useLockFile   bool
@@ -41,7 +65,7 @@ type packages struct {
 
 var (
 	opts         options
-	codeSuffixes = []string{".go", ".c", ".s", ".S", ".cc", ".cpp", ".cxx", ".h", ".hh", ".hpp", ".hxx"}
+	codeSuffixes = []string{".go", ".c", ".s", ".S", ".cc", ".cpp", ".cxx", ".h", ".hh", ".hpp", ".hxx", ".m", ".f", ".F", ".for", ".f90", ".swig", ".swigcxx", ".syso", ".a"}
 )
 
 const (
@@ -50,62 +74,211 @@ const (
 
 func init() {
 	cmd.PersistentFlags().BoolVar(&opts.dryrun, "dryrun", false, "just output what will be removed")
+	cmd.PersistentFlags().StringVar(&opts.format, "format", formatList, "output format of the removed files: list, tree (the vendor tree with removed entries marked) or diff (git diff --stat like)")
+	cmd.PersistentFlags().IntVar(&opts.depth, "depth", 0, "with --format tree collapse the directories deeper than depth (0 means no limit)")
 	cmd.PersistentFlags().BoolVar(&opts.onlyCode, "only-code", false, "keep only source code files (including go test files)")
-	cmd.PersistentFlags().BoolVar(&opts.noTests, "no-tests", false, "remove also go test files (requires --only-code)")
+	cmd.PersistentFlags().BoolVar(&opts.noTests, "no-tests", false, "remove also go test files, test assembly files, testdata directories and the packages imported only by the dependencies tests")
 	cmd.PersistentFlags().BoolVar(&opts.noLegalFiles, "no-legal-files", false, "remove also licenses and legal files")
-	cmd.PersistentFlags().StringSliceVar(&opts.keepPatterns, "keep", []string{}, "A pattern to keep additional files inside needed packages. The pattern match will be relative to the deeper vendor dir. Supports double star (**) patterns. (see https://golang.org/pkg/path/filepath/#Match and https://github.com/bmatcuk/doublestar). Can be specified multiple times. For example to keep all the files with json extension use the '**/*.json' pattern.")
+	cmd.PersistentFlags().StringSliceVar(&opts.keepPatterns, "keep", []string{}, "A pattern to keep additional files inside needed packages. The pattern match will be relative to the deeper vendor dir. Supports double star (**) patterns. (see https://golang.org/pkg/path/filepath/#Match and https://github.com/bmatcuk/doublestar). Can be specified multiple times. For example to keep all the files with json extension use the '**/*.json' pattern. Use 'import/path:pattern' to match all the files of a dependency relative to its root (':pattern' for every locked dependency) and a leading '!' to exclude the files matched by the previous patterns.")
+
+	cmd.PersistentFlags().BoolVar(&opts.keepGenerateSources, "keep-generate-sources", false, "keep also the files referenced by go:generate directives and the idl files (.proto, .fbs, .thrift, .y) of generated files inside needed packages")
+	cmd.PersistentFlags().StringSliceVar(&opts.keepRepos, "keep-repo", []string{}, "the import path of a dependency to keep entirely, at every vendor level, ignoring the package, --only-code and --no-tests rules (i.e. dependencies shipping runtime data or run from scripts). Can be specified multiple times")
+	cmd.PersistentFlags().BoolVar(&opts.syncLock, "sync-lock", false, "rewrite glide.lock removing the subpackages and dependencies that are no longer in the vendor dir")
+	cmd.PersistentFlags().BoolVar(&opts.gitStage, "git-stage", false, "stage the removed files in the git index")
+	cmd.PersistentFlags().StringVar(&opts.gitCommit, "git-commit", "", "stage the removed files and commit them with the provided message")
+	cmd.PersistentFlags().StringVar(&opts.vendorDir, "vendor-dir", "", "the vendor dir to clean. Defaults to the vendor dir inside the project dir")
+	cmd.Flags().StringVar(&opts.recursive, "recursive", "", "clean in parallel all the projects (directories containing glide.yaml) under the provided root dir, skipping the glide.yaml excludeDirs")
+	cmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "copy the kept files (hard linking or reflinking them when possible) to the provided empty dir instead of removing the unused ones from the vendor dir")
+	cmd.PersistentFlags().BoolVar(&opts.followSymlinks, "follow-symlinks", false, "analyze the contents of the symlinked directories inside the vendor dir like normal directories. The files are never removed through a symlink")
+	cmd.PersistentFlags().StringVar(&opts.configFile, "config", "", "the glide-vc config file. Defaults to "+configFile+" inside the project root, if it exists.")
+
+	cmd.PersistentFlags().BoolVar(&opts.useImportGraph, "use-import-graph", false, "use the import graph of the project go files instead of glide list to determine imports. With --use-lock-file only the locked packages in the import graph are kept and the stale lock entries are reported")
+
+	cmd.PersistentFlags().StringVar(&opts.testImports, "test-imports", "", "how to handle the vendored packages imported only by test files: keep (keep also the packages imported by dependencies tests), drop (remove them) or only-direct (keep only the ones imported by the project tests). Works with every import resolution mode")
 
 	cmd.PersistentFlags().BoolVar(&opts.useLockFile, "use-lock-file", false, "use glide.lock instead of glide list to determine imports")
-	cmd.PersistentFlags().BoolVar(&opts.noTestImports, "no-test-imports", false, "remove also testImport vendor directories. Works only with --use-lock-file")
+	cmd.PersistentFlags().BoolVar(&opts.noTestImports, "no-test-imports", false, "remove also testImport vendor directories. Works only with --use-lock-file or --use-import-graph")
 }
 
 func main() {
-	cmd.Execute()
+	// The root command doesn't accept arguments when it has subcommands: if
+	// no subcommand is requested remove them so the project dir can be
+	// provided.
+	if c, _, err := cmd.Find(os.Args[1:]); err != nil && c == cmd && os.Args[1] != "help" {
+		cmd.RemoveCommand(cmd.Commands()...)
+	}
+	// cobra already reported the error with the command usage
+	if err := cmd.Execute(); err != nil {
+		os.Exit(exitUsage)
+	}
 }
 
 func glidevc(cmd *cobra.Command, args []string) {
-	if opts.noTests && !opts.onlyCode {
-		fmt.Fprintln(os.Stderr, "--no-tests requires --only-code")
-		os.Exit(1)
+	if err := validateOptions(); err != nil {
+		fatal(err)
+	}
+
+	if opts.recursive != "" {
+		if len(args) > 0 || opts.vendorDir != "" || opts.outputDir != "" {
+			fatal(usageErrorf("--recursive cannot be used with a project dir, --vendor-dir or --output-dir"))
+		}
+		failed, err := cleanupRecursive(stdout(), opts.recursive)
+		if err != nil {
+			fatal(err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
-	if err := cleanup("."); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	root, err := projectRoot(args)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := cleanup(stdout(), root); err != nil {
+		fatal(err)
 	}
 }
 
-func glideLockImports(path string) ([]string, error) {
-	yml, err := ioutil.ReadFile(filepath.Join(path, gpath.LockFile))
+func validateOptions() error {
+	switch opts.testImports {
+	case "", testImportsKeep, testImportsDrop, testImportsOnlyDirect:
+	default:
+		return usageErrorf("unknown test imports mode %q", opts.testImports)
+	}
+
+	switch opts.format {
+	case formatList, formatTree, formatDiff:
+	default:
+		return usageErrorf("unknown format %q", opts.format)
+	}
+
+	if opts.outputDir != "" && (opts.gitStage || opts.gitCommit != "" || opts.syncLock) {
+		return usageErrorf("--output-dir cannot be used with --git-stage, --git-commit or --sync-lock")
+	}
+	return nil
+}
+
+// vendorPath returns the absolute path, with the symlinks resolved, of the
+// vendor dir to clean: the one provided with --vendor-dir or the one inside
+// the project dir.
+func vendorPath(path string) (string, error) {
+	vpath := opts.vendorDir
+	if vpath == "" {
+		vpath = filepath.Join(path, gpath.VendorDir)
+	}
+	vpath, err := filepath.Abs(vpath)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	fi, err := os.Stat(vpath)
+	if os.IsNotExist(err) {
+		return "", vendorDirErrorf("cannot find vendor dir %s", vpath)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", vendorDirErrorf("vendor dir %s is not a directory", vpath)
+	}
+	return filepath.EvalSymlinks(vpath)
+}
+
+// checkVendor verifies that the vendor dir can be cleaned: when the imports
+// are determined using glide list the vendor dir must be the project one
+// since glide doesn't know about other vendor dirs. The locked dependencies
+// missing from the vendor dir, like the unused ones removed by a previous
+// run, are only reported since they could be a vendor dir not matching the
+// project glide.lock.
+func checkVendor(path, vpath string) error {
+	if !opts.useImportGraph && !opts.useLockFile {
+		defaultVpath, err := filepath.EvalSymlinks(filepath.Join(path, gpath.VendorDir))
+		if err != nil || defaultVpath != vpath {
+			return vendorDirErrorf("vendor dir %s is not the project vendor dir: use --use-lock-file or --use-import-graph to clean it", vpath)
+		}
+	}
+
+	lock, err := readLockFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, l := range lock.Imports {
+		if _, err := os.Stat(filepath.Join(vpath, filepath.FromSlash(l.Name))); os.IsNotExist(err) {
+			missing = append(missing, l.Name)
+		}
 	}
+	if len(missing) > 0 {
+		logger.warn("locked dependencies missing from the vendor dir", "vendor", vpath, "lock", filepath.Join(path, gpath.LockFile), "missing", strings.Join(missing, ","))
+	}
+	return nil
+}
 
-	lock, err := cfg.LockfileFromYaml(yml)
+// resolverName returns the name of the imports resolver selected by the
+// options
+func resolverName() string {
+	switch {
+	case opts.useImportGraph && opts.useLockFile:
+		return "import-graph+lock-file"
+	case opts.useImportGraph:
+		return "import-graph"
+	case opts.useLockFile:
+		return "lock-file"
+	default:
+		return "glide-list"
+	}
+}
+
+func glideLockImports(path string) ([]string, error) {
+	lock, err := readLockFile(path)
 	if err != nil {
 		return nil, err
 	}
 
 	var imports []string
-	adder := func(locks cfg.Locks) {
-		for _, lock := range locks {
-			for _, subpackage := range lock.Subpackages {
-				imports = append(imports, lock.Name+"/"+subpackage)
-			}
-			imports = append(imports, lock.Name)
-		}
+	for _, l := range lockLocks(lock) {
+		imports = append(imports, lockPackages(l)...)
 	}
 
-	adder(lock.Imports)
+	return imports, nil
+}
+
+func readLockFile(path string) (*cfg.Lockfile, error) {
+	yml, err := ioutil.ReadFile(filepath.Join(path, gpath.LockFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.LockfileFromYaml(yml)
+}
+
+// lockLocks returns the locked dependencies, excluding the test imports if
+// --no-test-imports was specified.
+func lockLocks(lock *cfg.Lockfile) cfg.Locks {
+	locks := append(cfg.Locks{}, lock.Imports...)
 	if !opts.noTestImports {
-		adder(lock.DevImports)
+		locks = append(locks, lock.DevImports...)
 	}
+	return locks
+}
 
-	return imports, nil
+// lockPackages returns the packages of a locked dependency
+func lockPackages(lock *cfg.Lock) []string {
+	var packages []string
+	for _, subpackage := range lock.Subpackages {
+		packages = append(packages, lock.Name+"/"+subpackage)
+	}
+	return append(packages, lock.Name)
 }
 
 func glideListImports(path string) ([]string, error) {
-	cmd := exec.Command("glide", "list", "-output", "json", path)
+	cmd := exec.Command("glide", "list", "-output", "json", ".")
+	cmd.Dir = path
 	out, err := cmd.Output()
 	if err != nil {
 		return nil, err
@@ -120,19 +293,309 @@ func glideListImports(path string) ([]string, error) {
 	return list.Installed, nil
 }
 
-func cleanup(path string) error {
+// pathData is a path inside the vendor dir
+type pathData struct {
+	path  string
+	isDir bool
+}
+
+// cleanupPlan contains the keep decisions for the vendor dir
+type cleanupPlan struct {
+	vpath string
+	// the vendor dir path with a trailing separator
+	searchPath string
+	// the needed packages with the os specific path separator
+	pkgList []string
+	// the kept paths, relative to the vendor dir
+	markForKeep map[string]pathData
+	// the topmost removed paths
+	markForDelete []pathData
+}
+
+func cleanup(w io.Writer, path string) error {
+	plan, err := planCleanup(w, path)
+	if err != nil {
+		return err
+	}
+
+	// Report the entries before removing them
+	if opts.format == formatTree || opts.format == formatDiff {
+		done := logger.phase("report", "project", path, "format", opts.format)
+		entries, err := vendorEntries(plan.searchPath, func(localPath string) bool {
+			_, ok := plan.markForKeep[localPath]
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+		if opts.format == formatTree {
+			printTree(w, filepath.Base(plan.vpath), entries, opts.depth)
+		} else if err := printDiffStat(w, plan.vpath, entries); err != nil {
+			return err
+		}
+		done()
+	}
+
+	if opts.outputDir != "" {
+		return copyKept(w, plan, opts.outputDir)
+	}
+
+	useGit := !opts.dryrun && (opts.gitStage || opts.gitCommit != "")
+	if useGit {
+		if err := gitCheck(path, plan.vpath, opts.syncLock, opts.gitCommit != ""); err != nil {
+			return err
+		}
+	}
+
+	var (
+		removed      []string
+		removedLocal []string
+		removedDirs  []string
+		removedFiles int
+		removedSize  int64
+		derr         *deleteError
+	)
+
+	// Perform the actual delete.
+	done := logger.phase("remove", "project", path, "dryrun", opts.dryrun)
+	for _, marked := range plan.markForDelete {
+		localPath := strings.TrimPrefix(marked.path, plan.searchPath)
+		if opts.format == formatList || opts.format == "" {
+			if marked.isDir {
+				fmt.Fprintf(w, "Removing unused dir: %s\n", localPath)
+			} else {
+				fmt.Fprintf(w, "Removing unused file: %s\n", localPath)
+			}
+		}
+		if !opts.dryrun {
+			files, size, removedChildren, err := removePath(plan.vpath, marked.path, useGit)
+			// A failed removal could have removed some of the path contents
+			for _, child := range removedChildren {
+				removedLocal = append(removedLocal, strings.TrimPrefix(child, plan.searchPath))
+			}
+			if err != nil {
+				// Go on removing the other paths and report all
+				// the failures at the end
+				logger.debug("remove failed", "path", localPath, "error", err)
+				if derr == nil {
+					derr = &deleteError{errs: map[string]error{}}
+				}
+				derr.notRemoved = append(derr.notRemoved, localPath)
+				derr.errs[localPath] = err
+				continue
+			}
+			removedFiles += files
+			removedSize += size
+			removed = append(removed, marked.path)
+			removedLocal = append(removedLocal, localPath)
+			if marked.isDir {
+				removedDirs = append(removedDirs, localPath)
+			}
+		}
+	}
+
+	done()
+
+	// Leave the git index and the lock file untouched since the vendor dir
+	// is in an unexpected state
+	if derr != nil {
+		derr.removed = removedLocal
+		return derr
+	}
+
+	// Sync the lock file before the git step so its changes are staged and
+	// committed with the removals
+	lockChanged := false
+	if opts.syncLock {
+		done := logger.phase("sync lock", "project", path)
+		// The packages still in the vendor dir are the needed ones and the
+		// directories with kept files, like the ones of the kept
+		// repositories or protected by the ignore file
+		kept := map[string]struct{}{}
+		for _, name := range plan.pkgList {
+			if _, ok := plan.markForKeep[name]; ok {
+				kept[filepath.ToSlash(name)] = struct{}{}
+			}
+		}
+		for name, pd := range plan.markForKeep {
+			if !pd.isDir {
+				kept[filepath.ToSlash(filepath.Dir(name))] = struct{}{}
+			}
+		}
+		var err error
+		if lockChanged, err = syncLockFile(w, path, kept, opts.dryrun); err != nil {
+			return err
+		}
+		done()
+	}
+
+	if useGit {
+		done := logger.phase("git", "project", path)
+		if err := gitStage(path, removed); err != nil {
+			return err
+		}
+		if lockChanged {
+			if err := gitAdd(path, gpath.LockFile); err != nil {
+				return err
+			}
+		}
+		if opts.gitCommit != "" && (len(removed) > 0 || lockChanged) {
+			if err := gitCommit(path, opts.gitCommit, removedDirs, removedFiles, removedSize); err != nil {
+				return err
+			}
+		}
+		done()
+	}
+
+	return nil
+}
+
+// removePath removes a marked path. When count is true it returns the number
+// of files and the size of the removed path. When the removal fails it
+// returns the paths inside path that were removed anyway.
+func removePath(vpath, path string, count bool) (int, int64, []string, error) {
+	if err := checkRemovable(vpath, path); err != nil {
+		return 0, 0, nil, err
+	}
+	var (
+		files int
+		size  int64
+		err   error
+	)
+	if count {
+		if files, size, err = diskUsage(path); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	if removed, err := removeTree(path); err != nil {
+		return 0, 0, removed, err
+	}
+	return files, size, nil, nil
+}
+
+// removeTree removes path and all its contents bottom-up like os.RemoveAll,
+// going on after a failure. It returns the topmost removed paths, so path
+// itself if the removal succeeds.
+func removeTree(path string) ([]string, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var removed []string
+	if fi.IsDir() {
+		names, err := readDirNames(path)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		var firstErr error
+		for _, name := range names {
+			r, err := removeTree(filepath.Join(path, name))
+			removed = append(removed, r...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return removed, firstErr
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return removed, err
+	}
+	return []string{path}, nil
+}
+
+// planCleanup computes the paths of the vendor dir to keep and the ones to
+// remove without changing it.
+func planCleanup(w io.Writer, path string) (*cleanupPlan, error) {
 	var (
 		packages []string
 		err      error
 	)
 
-	if opts.useLockFile {
+	// Fail on bad patterns before doing anything
+	conf, ignoreRules, err := loadPatterns(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keepPatterns := parseKeepRules(opts.keepPatterns)
+
+	vpath, err := vendorPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVendor(path, vpath); err != nil {
+		return nil, err
+	}
+
+	logger.info("cleaning vendor dir", "project", path, "vendor", vpath)
+
+	resolver := resolverName()
+	done := logger.phase("resolve imports", "project", path, "resolver", resolver)
+	switch {
+	case opts.useImportGraph:
+		packages, err = importGraphImports(path, vpath, !opts.noTestImports, false)
+		if err != nil || !opts.useLockFile {
+			break
+		}
+		var lock *cfg.Lockfile
+		if lock, err = readLockFile(path); err == nil {
+			packages = pruneLockImports(w, vpath, lock, packages)
+		}
+	case opts.useLockFile:
 		packages, err = glideLockImports(path)
-	} else {
+	default:
 		packages, err = glideListImports(path)
 	}
 	if err != nil {
-		return err
+		return nil, &resolverError{resolver: resolver, err: err}
+	}
+	logger.info("resolved imports", "project", path, "resolver", resolver, "packages", len(packages))
+
+	// Remove the packages imported only by the dependencies tests that are
+	// removed. The import graph already ignores the dependencies tests.
+	if !opts.useImportGraph && conf.anyNoTests(opts) {
+		keepTests := func(dir string) bool {
+			localPath, err := filepath.Rel(vpath, dir)
+			if err != nil {
+				return true
+			}
+			lastVendorPath, err := getLastVendorPath(localPath)
+			if err != nil {
+				return true
+			}
+			_, override := conf.override(lastVendorPath)
+			return !override.apply(opts).noTests
+		}
+		depTestOnly, err := depTestOnlyPackages(path, vpath, keepTests)
+		if err != nil {
+			// The project could contain files that aren't valid go code
+			// (like templates): keep the packages instead of failing.
+			logger.warn("cannot find the packages imported only by the dependencies tests, keeping them", "project", path, "error", err)
+		} else {
+			packages = removePackages(packages, depTestOnly)
+		}
+	}
+
+	if opts.testImports != "" {
+		packages, err = applyTestImports(path, vpath, packages, opts.testImports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the tools and their imports
+	if len(conf.Tools) > 0 {
+		tools, err := toolImports(path, vpath, conf.Tools)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, tools...)
 	}
 
 	// The package list already have the path converted to the os specific
@@ -146,26 +609,56 @@ func cleanup(path string) error {
 			pkgMap[imp] = struct{}{}
 		}
 	}
-
-	vpath, err := gpath.Vendor()
-	if err != nil {
-		return err
-	}
-	if vpath == "" {
-		return fmt.Errorf("cannot find vendor dir")
+	done()
+	logger.info("needed packages", "project", path, "packages", len(pkgList))
+
+	// The dependency roots are needed only by the keep patterns relative to
+	// every dependency
+	var roots []string
+	if keepPatterns.anyDep() {
+		if roots, err = lockRoots(path); err != nil {
+			return nil, err
+		}
 	}
 
-	type pathData struct {
-		path  string
-		isDir bool
-	}
 	var searchPath string
 	markForKeep := map[string]pathData{}
 	markForDelete := []pathData{}
+	// kept files, needed to find additional files to keep
+	keptFiles := []string{}
+
+	keepPath := func(localPath string, isDir bool) {
+		// Keep all parent directories of current path
+		for curpath := localPath; curpath != "."; curpath = filepath.Dir(curpath) {
+			markForKeep[curpath] = pathData{curpath, true}
+		}
+		// Fix isDir property for current path
+		markForKeep[localPath] = pathData{localPath, isDir}
+	}
+
+	// keepAsset keeps an additional file required by the kept files
+	keepAsset := func(file, reason string) {
+		// Ignore files outside the vendor dir
+		if !strings.HasPrefix(file, searchPath) {
+			return
+		}
+		localPath := strings.TrimPrefix(file, searchPath)
+		if _, ok := markForKeep[localPath]; !ok {
+			fmt.Fprintf(w, "Keeping %s: %s\n", reason, localPath)
+			keepPath(localPath, false)
+		}
+	}
+
+	var keepRepos []string
+	for _, repo := range append(append([]string{}, conf.KeepRepos...), opts.keepRepos...) {
+		keepRepos = append(keepRepos, filepath.FromSlash(strings.Trim(repo, "/")))
+	}
+	foundRepos := map[string]struct{}{}
 
 	// Walk vendor directory
+	done = logger.phase("analyze vendor dir", "project", path)
 	searchPath = vpath + string(os.PathSeparator)
-	err = filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
+	err = walkVendor(w, vpath, opts.followSymlinks, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -174,11 +667,6 @@ func cleanup(path string) error {
 			return nil
 		}
 
-		// Short-circuit for test files
-		if opts.noTests && strings.HasSuffix(path, "_test.go") {
-			return nil
-		}
-
 		localPath := strings.TrimPrefix(path, searchPath)
 
 		lastVendorPath, err := getLastVendorPath(localPath)
@@ -187,7 +675,59 @@ func cleanup(path string) error {
 		}
 		lastVendorPathDir := filepath.Dir(lastVendorPath)
 
+		// Record the kept repositories found also when their paths are
+		// protected by the ignore file
+		repo := keptRepo(localPath, keepRepos)
+		if repo != "" {
+			foundRepos[repo] = struct{}{}
+		}
+
+		// Never remove the paths matched by the ignore file
+		ignored, err := ignoreRules.match(lastVendorPath, info.IsDir())
+		if err != nil {
+			return err
+		}
+		if ignored {
+			parentIgnored, err := ignoreRules.match(filepath.Dir(lastVendorPath), true)
+			if err != nil {
+				return err
+			}
+			if !parentIgnored {
+				fmt.Fprintf(w, "Keeping path protected by %s: %s\n", ignoreFile, localPath)
+			}
+			if info.IsDir() {
+				logger.debug("vendor directory", "path", localPath, "keep", true, "reason", "protected by "+ignoreFile)
+			}
+			keepPath(localPath, info.IsDir())
+			return nil
+		}
+
+		// Keep every path of the whole repositories
+		if repo != "" {
+			if info.IsDir() {
+				logger.debug("vendor directory", "path", localPath, "keep", true, "reason", "kept repository "+filepath.ToSlash(repo))
+			}
+			keepPath(localPath, info.IsDir())
+			if !info.IsDir() {
+				keptFiles = append(keptFiles, path)
+			}
+			return nil
+		}
+
+		// Apply the per dependency overrides
+		_, override := conf.override(lastVendorPath)
+		popts := override.apply(opts)
+
+		// Short-circuit for test files
+		if popts.noTests && isTestPath(lastVendorPath) {
+			if info.IsDir() {
+				logger.debug("vendor directory", "path", localPath, "keep", false, "reason", "test data")
+			}
+			return nil
+		}
+
 		keep := false
+		inPackage := false
 
 		for _, name := range pkgList {
 			// if a directory is a needed package then keep it
@@ -199,45 +739,89 @@ func cleanup(path string) error {
 			}
 
 			// Keep legal files in directories that are the parent of a needed package
-			keep = keep || !opts.noLegalFiles && IsLegalFile(path) && isParentDirectory(lastVendorPathDir, name)
+			keep = keep || !popts.noLegalFiles && conf.isLegalFile(path) && isParentDirectory(lastVendorPathDir, name)
 
 			// The remaining tests only apply if the file is in a needed package
 			if name != lastVendorPathDir {
 				continue
 			}
+			inPackage = true
 
 			// Keep everything unless --only-code was specified
-			keep = keep || !opts.onlyCode
+			keep = keep || !popts.onlyCode
 
 			// Always keep code files
-			for _, suffix := range codeSuffixes {
-				keep = keep || strings.HasSuffix(path, suffix)
-			}
+			keep = keep || conf.isCodeFile(path)
+		}
 
-			// Match keep patterns
-			for _, keepPattern := range opts.keepPatterns {
-				ok, err := doublestar.Match(keepPattern, lastVendorPath)
-				// TODO(sgotti) if a bad pattern is encountered stop here. Actually there's no function to verify a pattern before using it, perhaps just a fake match at the start will work.
-				if err != nil {
-					return fmt.Errorf("bad pattern: %q", keepPattern)
+		// Match the per dependency keep patterns and the keep patterns
+		if !info.IsDir() && !keep {
+			if override != nil {
+				if keep, err = override.keepRules.match(lastVendorPath, inPackage, pkgList, roots); err != nil {
+					return err
+				}
+			}
+			if !keep {
+				if keep, err = keepPatterns.match(lastVendorPath, inPackage, pkgList, roots); err != nil {
+					return err
 				}
-				keep = keep || ok
 			}
 		}
 
+		if info.IsDir() {
+			logger.debug("vendor directory", "path", localPath, "package", filepath.ToSlash(lastVendorPath), "needed", keep)
+		}
 		if keep {
-			// Keep all parent directories of current path
-			for curpath := localPath; curpath != "."; curpath = filepath.Dir(curpath) {
-				markForKeep[curpath] = pathData{curpath, true}
+			keepPath(localPath, info.IsDir())
+			if !info.IsDir() {
+				keptFiles = append(keptFiles, path)
 			}
-			// Fix isDir property for current path
-			markForKeep[localPath] = pathData{localPath, info.IsDir()}
 		}
 
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Keep the assets embedded by the kept go files
+	for _, goFile := range keptFiles {
+		if !strings.HasSuffix(goFile, ".go") {
+			continue
+		}
+		patterns, err := embedPatterns(goFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range patterns {
+			files, err := embedFiles(filepath.Dir(goFile), pattern)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				keepAsset(file, "embedded asset")
+			}
+		}
+	}
+
+	// Keep the files referenced by cgo directives and includes of the kept files
+	cgoFiles, err := cgoAssets(keptFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range cgoFiles {
+		keepAsset(file, "cgo asset")
+	}
+
+	// Keep the files needed to regenerate the code of the kept files
+	if opts.keepGenerateSources {
+		sources, err := generateSources(keptFiles)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range sources {
+			keepAsset(file, "generate source")
+		}
 	}
 
 	// Generate deletion list
@@ -262,26 +846,24 @@ func cleanup(path string) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	done()
+	logger.info("analyzed vendor dir", "project", path, "kept", len(markForKeep), "unused", len(markForDelete))
 
-	// Perform the actual delete.
-	for _, marked := range markForDelete {
-		localPath := strings.TrimPrefix(marked.path, searchPath)
-		if marked.isDir {
-			fmt.Printf("Removing unused dir: %s\n", localPath)
-		} else {
-			fmt.Printf("Removing unused file: %s\n", localPath)
-		}
-		if !opts.dryrun {
-			rerr := os.RemoveAll(marked.path)
-			if rerr != nil {
-				return rerr
-			}
+	for _, repo := range keepRepos {
+		if _, ok := foundRepos[repo]; !ok {
+			logger.warn("repository to keep not found in vendor dir", "project", path, "repo", filepath.ToSlash(repo))
 		}
 	}
 
-	return nil
+	return &cleanupPlan{
+		vpath:         vpath,
+		searchPath:    searchPath,
+		pkgList:       pkgList,
+		markForKeep:   markForKeep,
+		markForDelete: markForDelete,
+	}, nil
 }
 
 func getLastVendorPath(path string) (string, error) {
@@ -293,6 +875,46 @@ func getLastVendorPath(path string) (string, error) {
 	return path, nil
 }
 
+// keptRepo returns the repository, among repos, containing the path at any
+// vendor level, or an empty string.
+func keptRepo(localPath string, repos []string) string {
+	if len(repos) == 0 {
+		return ""
+	}
+	// The path relative to every vendor dir containing it
+	paths := []string{localPath}
+	for curpath := filepath.Dir(localPath); curpath != "."; curpath = filepath.Dir(curpath) {
+		if filepath.Base(curpath) == "vendor" {
+			rel, err := filepath.Rel(curpath, localPath)
+			if err == nil {
+				paths = append(paths, rel)
+			}
+		}
+	}
+	for _, p := range paths {
+		for _, repo := range repos {
+			if isParentDirectory(repo, p) {
+				return repo
+			}
+		}
+	}
+	return ""
+}
+
+// isTestPath returns true for go test files, assembly test files and
+// testdata directories (and their contents).
+func isTestPath(path string) bool {
+	if strings.HasSuffix(path, goTestSuffix) || strings.HasSuffix(path, "_test.s") {
+		return true
+	}
+	for _, elem := range strings.Split(path, string(filepath.Separator)) {
+		if elem == "testdata" {
+			return true
+		}
+	}
+	return false
+}
+
 func isParentDirectory(parent, child string) bool {
 	if !strings.HasSuffix(parent, string(filepath.Separator)) {
 		parent += string(filepath.Separator)
@@ -331,13 +953,17 @@ var LegalFileSubstring = []string{
 // IsLegalFile returns true if the file is likely to contain some type
 // of of legal declaration or licensing information
 func IsLegalFile(path string) bool {
+	return isLegalFile(path, LicenseFilePrefix, LegalFileSubstring)
+}
+
+func isLegalFile(path string, licenseFilePrefixes, legalFileSubstrings []string) bool {
 	lowerfile := strings.ToLower(filepath.Base(path))
-	for _, prefix := range LicenseFilePrefix {
+	for _, prefix := range licenseFilePrefixes {
 		if strings.HasPrefix(lowerfile, prefix) && !strings.HasSuffix(lowerfile, goTestSuffix) {
 			return true
 		}
 	}
-	for _, substring := range LegalFileSubstring {
+	for _, substring := range legalFileSubstrings {
 		if strings.Contains(lowerfile, substring) && !strings.HasSuffix(lowerfile, goTestSuffix) {
 			return true
 		}
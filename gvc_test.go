@@ -1,12 +1,26 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/Masterminds/glide/cfg"
+	"github.com/bmatcuk/doublestar"
+	"github.com/fsnotify/fsnotify"
 )
 
 type FileInfo struct {
@@ -72,11 +86,22 @@ func checkExpectedVendor(t *testing.T, dir string, exp []FileInfo) error {
 }
 
 type testData struct {
-	tree          []FileInfo
-	lockdata      string
-	mainfile      string
+	tree     []FileInfo
+	lockdata string
+	mainfile string
+	config   string
+	// contents of the vendor files (default to an empty go package)
+	contents      map[string]string
 	expectedFiles []FileInfo
-	opts          options
+	// expected lock imports and their subpackages (checked if not nil)
+	expectedLockImports map[string][]string
+	// initialize a git repository with all the project files committed
+	gitInit bool
+	// called before the cleanup
+	prepare func(dir string) error
+	// additional checks on the project dir
+	check func(dir string) error
+	opts  options
 }
 
 func TestCleanup(t *testing.T) {
@@ -190,6 +215,58 @@ import (
 			},
 			opts: options{onlyCode: true, noTests: true, noLegalFiles: true, keepPatterns: []string{"**/*.json"}},
 		},
+		{
+			tree:     tree,
+			lockdata: lockdata,
+			mainfile: mainfile,
+			config: `
+packages:
+  host02/org02/repo02:
+    onlyCode: false
+  host01/org01/repo01:
+    keep:
+    - subpkg01/*.json
+`,
+			expectedFiles: []FileInfo{
+				{"host01", true},
+				{"host01/org01", true},
+				{"host01/org01/repo01", true},
+				{"host01/org01/repo01/file01.go", false},
+				{"host01/org01/repo01/subpkg01", true},
+				{"host01/org01/repo01/subpkg01/file02.go", false},
+				{"host01/org01/repo01/subpkg01/file03.c", false},
+				{"host01/org01/repo01/subpkg01/file04.s", false},
+				{"host01/org01/repo01/subpkg01/file05.S", false},
+				{"host01/org01/repo01/subpkg01/file06.cc", false},
+				{"host01/org01/repo01/subpkg01/file07.cpp", false},
+				{"host01/org01/repo01/subpkg01/file09.cxx", false},
+				{"host01/org01/repo01/subpkg01/file10.h", false},
+				{"host01/org01/repo01/subpkg01/file11.hh", false},
+				{"host01/org01/repo01/subpkg01/file12.hpp", false},
+				{"host01/org01/repo01/subpkg01/file13.hxx", false},
+				{"host01/org01/repo01/subpkg01/file.json", false},
+				{"host01/org01/repo01/vendor", true},
+				{"host01/org01/repo01/vendor/host02", true},
+				{"host01/org01/repo01/vendor/host02/org02", true},
+				{"host01/org01/repo01/vendor/host02/org02/repo02", true},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/README", false},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/LICENSE", false},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/file03.go", false},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/subpkg02", true},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/subpkg02/LICENSE", false},
+				{"host01/org01/repo01/vendor/host02/org02/repo02/subpkg02/file04.go", false},
+				{"host02", true},
+				{"host02/org02", true},
+				{"host02/org02/repo02", true},
+				{"host02/org02/repo02/README", false},
+				{"host02/org02/repo02/LICENSE", false},
+				{"host02/org02/repo02/file03.go", false},
+				{"host02/org02/repo02/subpkg02", true},
+				{"host02/org02/repo02/subpkg02/LICENSE", false},
+				{"host02/org02/repo02/subpkg02/file04.go", false},
+			},
+			opts: options{onlyCode: true, noTests: true, noLegalFiles: true},
+		},
 
 		{
 			tree:     tree,
@@ -381,95 +458,2538 @@ import (
 	}
 
 	for _, useLockFile := range []bool{false, true} {
-		for i, td := range tests {
-			t.Logf("Test #%d", i)
-			td.opts.useLockFile = useLockFile
-			if err := testCleanup(t, &td); err != nil {
-				t.Fatalf("#%d: unexpected error: %v", i, err)
+		for _, useImportGraph := range []bool{false, true} {
+			for i, td := range tests {
+				t.Logf("Test #%d", i)
+				td.opts.useLockFile = useLockFile
+				td.opts.useImportGraph = useImportGraph
+				if err := testCleanup(t, &td); err != nil {
+					t.Fatalf("#%d: unexpected error: %v", i, err)
+				}
 			}
 		}
 	}
 }
 
-func testCleanup(t *testing.T, td *testData) error {
-	tmpDir, err := ioutil.TempDir("", "glidevc")
-	if err != nil {
-		return err
+func TestCleanupEmbed(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/LICENSE", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/templates/index.tmpl", false},
+		{"host01/org01/repo01/templates/README", false},
+		{"host01/org01/repo01/static/css/style.css", false},
+		{"host01/org01/repo01/static/.hidden", false},
+		{"host01/org01/repo01/static/_ignored/file", false},
+		{"host01/org01/repo01/all/.hidden", false},
+		{"host01/org01/repo01/my file.txt", false},
+		{"host01/org01/repo01/unused/file.txt", false},
 	}
-	//defer os.RemoveAll(tmpDir)
 
-	wd, _ := os.Getwd()
-	defer os.Chdir(wd)
-	if err := os.Chdir(tmpDir); err != nil {
-		return fmt.Errorf("Could not change to dir %s: %v", wd, err)
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/file01.go": `package repo01
+
+import "embed"
+
+//go:embed templates/*.tmpl static
+//go:embed all:all "my file.txt"
+var content embed.FS
+`,
 	}
 
-	// Create empty glide.yaml (currently not used for hash checking)
-	if err := ioutil.WriteFile(filepath.Join(tmpDir, "glide.yaml"), nil, 0666); err != nil {
-		return fmt.Errorf("failed to create glide.yaml file: %v", err)
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/templates", true},
+			{"host01/org01/repo01/templates/index.tmpl", false},
+			{"host01/org01/repo01/static", true},
+			{"host01/org01/repo01/static/css", true},
+			{"host01/org01/repo01/static/css/style.css", false},
+			{"host01/org01/repo01/all", true},
+			{"host01/org01/repo01/all/.hidden", false},
+			{"host01/org01/repo01/my file.txt", false},
+		},
+		opts: options{onlyCode: true, noTests: true, noLegalFiles: true},
 	}
 
-	// Create glide.lock file
-	if err := ioutil.WriteFile(filepath.Join(tmpDir, "glide.lock"), []byte(td.lockdata), 0666); err != nil {
-		return fmt.Errorf("failed to create glide.lock file: %v", err)
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 	}
+}
 
-	// Create main.go file
-	if err := ioutil.WriteFile(filepath.Join(tmpDir, "main.go"), []byte(td.mainfile), 0666); err != nil {
-		return fmt.Errorf("failed to create main.go file: %v", err)
+func TestCleanupCgo(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/pkg/README", false},
+		{"host01/org01/repo01/pkg/file01.go", false},
+		{"host01/org01/repo01/pkg/file02.syso", false},
+		{"host01/org01/repo01/include/foo.h", false},
+		{"host01/org01/repo01/include/sub/baz.h", false},
+		{"host01/org01/repo01/internal/bar.h", false},
+		{"host01/org01/repo01/internal/unused.h", false},
+		{"host01/org01/repo01/lib/libfoo.a", false},
+		{"host01/org01/repo01/lib/README", false},
+		{"host01/org01/repo01/libs/libbar.so", false},
+		{"host01/org01/repo01/libs/libbar.h", false},
+		{"host01/org01/repo01/libs/sub/libbaz.a", false},
+		{"host01/org01/repo01/unused/file.c", false},
 	}
 
-	if err := createVendorTree(t, tmpDir, td.tree); err != nil {
-		return err
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - pkg
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01/pkg"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/pkg/file01.go": `package pkg
+
+// #cgo CFLAGS: -I${SRCDIR}/../include
+// #cgo linux LDFLAGS: ${SRCDIR}/../lib/libfoo.a -L${SRCDIR}/../libs -lbar -lm
+// #include <stdio.h>
+// #include "foo.h"
+import "C"
+`,
+		"host01/org01/repo01/include/foo.h": `#include "../internal/bar.h"
+`,
 	}
 
-	opts = td.opts
-	if err := cleanup(tmpDir); err != nil {
-		return err
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/pkg", true},
+			{"host01/org01/repo01/pkg/file01.go", false},
+			{"host01/org01/repo01/pkg/file02.syso", false},
+			{"host01/org01/repo01/include", true},
+			{"host01/org01/repo01/include/foo.h", false},
+			{"host01/org01/repo01/internal", true},
+			{"host01/org01/repo01/internal/bar.h", false},
+			{"host01/org01/repo01/lib", true},
+			{"host01/org01/repo01/lib/libfoo.a", false},
+			{"host01/org01/repo01/libs", true},
+			{"host01/org01/repo01/libs/libbar.so", false},
+		},
+		opts: options{onlyCode: true, noTests: true, noLegalFiles: true},
 	}
 
-	if err := checkExpectedVendor(t, tmpDir, td.expectedFiles); err != nil {
-		return err
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 	}
-	return nil
 }
 
-func TestGetLastVendorPath(t *testing.T) {
-	tests := map[string]string{
-		"host1/org1/repo1":                                                 "host1/org1/repo1",
-		"host1/org1/repo1/vendor/host2/org2/repo2":                         "host2/org2/repo2",
-		"host1/org1/repo1/vendor/host2/org2/repo2/vendor/host3/org3/repo3": "host3/org3/repo3",
+func TestCleanupCgoIncludeDirs(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/hdrs/common.h", false},
+		{"host01/org01/repo01/hdrs/unused.h", false},
+		{"host01/org01/repo01/docs/big.pdf", false},
+		{"host01/org01/repo01/other/b.go", false},
+		{"host01/org01/repo01/other/b_test.go", false},
+		{"host01/org01/repo01/sub/a.go", false},
+		{"host01/org01/repo01/sub/a_test.go", false},
+		{"host01/org01/repo01/sub/README.md", false},
+		{"host01/org01/repo01/sub/inc/local.h", false},
+		{"host01/org01/repo01/sub/inc/unused.h", false},
+		{"host01/org01/repo01/sub/subpkg/c.go", false},
 	}
 
-	for input, expected := range tests {
-		got, err := getLastVendorPath(filepath.FromSlash(input))
-		if err != nil {
-			t.Fatalf("Unexpected error: %v", err)
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - sub
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01/sub"
+)
+`
+
+	// The include dirs only resolve the #include lines: none of their other
+	// files is kept
+	contents := map[string]string{
+		"host01/org01/repo01/sub/a.go": `package sub
+
+// #cgo CFLAGS: -I${SRCDIR} -I${SRCDIR}/..
+// #include "hdrs/common.h"
+// #include "inc/local.h"
+import "C"
+`,
+	}
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/hdrs", true},
+			{"host01/org01/repo01/hdrs/common.h", false},
+			{"host01/org01/repo01/sub", true},
+			{"host01/org01/repo01/sub/a.go", false},
+			{"host01/org01/repo01/sub/inc", true},
+			{"host01/org01/repo01/sub/inc/local.h", false},
+		},
+		opts: options{onlyCode: true, noTests: true, noLegalFiles: true},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-		if got != filepath.FromSlash(expected) {
-			t.Fatalf("got=%q, expected=%q", got, expected)
+	}
+}
+
+func TestCleanupGenerateSources(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/pkg/README", false},
+		{"host01/org01/repo01/pkg/file01.go", false},
+		{"host01/org01/repo01/pkg/parser.y", false},
+		{"host01/org01/repo01/pkg/types.pb.go", false},
+		{"host01/org01/repo01/pkg/types.proto", false},
+		{"host01/org01/repo01/proto/api.proto", false},
+		{"host01/org01/repo01/proto/other.proto", false},
+		{"host01/org01/repo01/unused/unused.proto", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - pkg
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01/pkg"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/pkg/file01.go": `package pkg
+
+//go:generate protoc --proto_path=../proto --go_out=. ../proto/api.proto
+//go:generate goyacc -o parser.go parser.y
+`,
+	}
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/pkg", true},
+			{"host01/org01/repo01/pkg/file01.go", false},
+			{"host01/org01/repo01/pkg/parser.y", false},
+			{"host01/org01/repo01/pkg/types.pb.go", false},
+			{"host01/org01/repo01/pkg/types.proto", false},
+			{"host01/org01/repo01/proto", true},
+			{"host01/org01/repo01/proto/api.proto", false},
+			{"host01/org01/repo01/proto/other.proto", false},
+		},
+		opts: options{onlyCode: true, noTests: true, noLegalFiles: true, keepGenerateSources: true},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
 	}
 }
 
-func TestIsParentDirectory(t *testing.T) {
-	type testData2 struct {
-		Parent string
-		Child  string
+func TestCleanupImportGraph(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/LICENSE", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/subpkg01/file02.go", false},
+		{"host01/org01/repo01/subpkg02/file03.go", false},
+		{"host02/org02/repo02/file04.go", false},
+		{"host03/org03/repo03/file05.go", false},
 	}
-	tests := map[testData2]bool{
-		{"foo", "foo"}:     true,
-		{"foo", "foo/bar"}: true,
-		{"foo", "foobar"}:  false,
-		{"foo/", "foo"}:    true,
-		{"foo", "foo/"}:    true,
-		{"foo/", "foo/"}:   true,
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - subpkg01
+  - subpkg02
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host03/org03/repo03
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01/subpkg01"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/subpkg01/file02.go": `package subpkg01
+
+import (
+	"fmt"
+
+	_ "host02/org02/repo02"
+)
+`,
 	}
 
-	for input, expected := range tests {
-		got := isParentDirectory(filepath.FromSlash(input.Parent), filepath.FromSlash(input.Child))
-		if got != expected {
-			t.Fatalf("got=%t, expected=%t", got, expected)
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/LICENSE", false},
+			{"host01/org01/repo01/subpkg01", true},
+			{"host01/org01/repo01/subpkg01/file02.go", false},
+			{"host02", true},
+			{"host02/org02", true},
+			{"host02/org02/repo02", true},
+			{"host02/org02/repo02/file04.go", false},
+		},
+		opts: options{onlyCode: true, noTests: true},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		td.opts.useImportGraph = true
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Sync the lock file with the kept packages
+	td.opts.syncLock = true
+	td.expectedLockImports = map[string][]string{
+		"host01/org01/repo01": {"subpkg01"},
+		"host02/org02/repo02": nil,
+	}
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPruneLockImports(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := createVendorTree(t, tmpDir, []FileInfo{
+		// The root of repo01 is a package, the one of repo02 isn't
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/subpkg01/file02.go", false},
+		{"host02/org02/repo02/README", false},
+		{"host02/org02/repo02/file_test.go", false},
+		{"host02/org02/repo02/unix/file03.go", false},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lock := &cfg.Lockfile{
+		Imports: cfg.Locks{
+			{Name: "host01/org01/repo01", Subpackages: []string{"subpkg01"}},
+			{Name: "host02/org02/repo02", Subpackages: []string{"unix"}},
+			{Name: "host03/org03/repo03"},
+		},
+	}
+	graph := []string{"host01/org01/repo01/subpkg01", "host02/org02/repo02/unix"}
+
+	opts = options{}
+	var out bytes.Buffer
+	imports := pruneLockImports(&out, filepath.Join(tmpDir, "vendor"), lock, graph)
+	if !reflect.DeepEqual(imports, graph) {
+		t.Fatalf("got imports %v, expected %v", imports, graph)
+	}
+	expected := "Locked package not imported: host01/org01/repo01\nLocked dependency not imported: host03/org03/repo03\n"
+	if out.String() != expected {
+		t.Fatalf("got output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestCleanupGit(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/README", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		gitInit:  true,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+		},
+		check: func(dir string) error {
+			out, err := git(dir, nil, "status", "--porcelain")
+			if err != nil {
+				return err
+			}
+			if len(out) != 0 {
+				return fmt.Errorf("unexpected git status: %s", out)
+			}
+			out, err = git(dir, nil, "log", "-1", "--format=%B")
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(string(out), "Clean vendor\n\nRemoved directories:\n- host02\n") {
+				return fmt.Errorf("unexpected commit message: %s", out)
+			}
+			return nil
+		},
+		opts: options{onlyCode: true, useLockFile: true, gitCommit: "Clean vendor"},
+	}
+
+	os.Setenv("GIT_AUTHOR_NAME", "test")
+	os.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	os.Setenv("GIT_COMMITTER_NAME", "test")
+	os.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Refuse to run with unrelated changes inside vendor
+	td.prepare = func(dir string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "vendor", "host01/org01/repo01/file01.go"), []byte("package repo01\n\n// changed"), 0666)
+	}
+	if err := testCleanup(t, &td); err == nil || !strings.Contains(err.Error(), "unstaged changes") {
+		t.Fatalf("expected unstaged changes error, got: %v", err)
+	}
+
+	// The synced lock file is committed with the removals
+	td.lockdata = `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	td.prepare = nil
+	td.opts = options{onlyCode: true, useImportGraph: true, useLockFile: true, syncLock: true, gitCommit: "Clean vendor"}
+	td.expectedLockImports = map[string][]string{
+		"host01/org01/repo01": nil,
+	}
+	check := td.check
+	td.check = func(dir string) error {
+		if err := check(dir); err != nil {
+			return err
+		}
+		out, err := git(dir, nil, "show", "--name-only", "--format=", "HEAD")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(out), "glide.lock\n") {
+			return fmt.Errorf("glide.lock not committed: %s", out)
+		}
+		return nil
+	}
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Refuse to run with unrelated changes to the lock file
+	td.prepare = func(dir string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "glide.lock"), []byte(td.lockdata+"# changed\n"), 0666)
+	}
+	if err := testCleanup(t, &td); err == nil || !strings.Contains(err.Error(), "glide.lock has unstaged changes") {
+		t.Fatalf("expected unstaged changes error, got: %v", err)
+	}
+}
+
+func TestCleanupIgnoreFile(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/scripts/build.sh", false},
+		{"host01/org01/repo01/scripts/skip.sh", false},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/file03.go", false},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/scripts/run.sh", false},
+		{"host02/org02/repo02/file02.go", false},
+		{"host02/org02/repo02/Makefile.inc", false},
+		{"host02/org02/repo02/README", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	ignore := `# vendored scripts used by the Makefile
+scripts/
+!host01/org01/repo01/scripts/skip.sh
+
+/host02/org02/repo02/*.inc
+`
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		prepare: func(dir string) error {
+			return ioutil.WriteFile(filepath.Join(dir, ignoreFile), []byte(ignore), 0666)
+		},
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/scripts", true},
+			{"host01/org01/repo01/scripts/build.sh", false},
+			{"host01/org01/repo01/vendor", true},
+			{"host01/org01/repo01/vendor/host03", true},
+			{"host01/org01/repo01/vendor/host03/org03", true},
+			{"host01/org01/repo01/vendor/host03/org03/repo03", true},
+			{"host01/org01/repo01/vendor/host03/org03/repo03/scripts", true},
+			{"host01/org01/repo01/vendor/host03/org03/repo03/scripts/run.sh", false},
+			{"host02", true},
+			{"host02/org02", true},
+			{"host02/org02/repo02", true},
+			{"host02/org02/repo02/Makefile.inc", false},
+		},
+		opts: options{onlyCode: true},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCleanupTools(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+		{"host02/org02/repo02/cmd/tool/main.go", false},
+		{"host02/org02/repo02/cmd/other/main.go", false},
+		{"host03/org03/repo03/file03.go", false},
+		{"host04/org04/repo04/file04.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	contents := map[string]string{
+		"host02/org02/repo02/cmd/tool/main.go": `package main
+
+import (
+	_ "host03/org03/repo03"
+)
+`,
+	}
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		config: `
+tools:
+- host02/org02/repo02/cmd/tool
+`,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+			{"host02", true},
+			{"host02/org02", true},
+			{"host02/org02/repo02", true},
+			{"host02/org02/repo02/cmd", true},
+			{"host02/org02/repo02/cmd/tool", true},
+			{"host02/org02/repo02/cmd/tool/main.go", false},
+			{"host03", true},
+			{"host03/org03", true},
+			{"host03/org03/repo03", true},
+			{"host03/org03/repo03/file03.go", false},
+		},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		for _, useImportGraph := range []bool{false, true} {
+			td.opts.useLockFile = useLockFile
+			td.opts.useImportGraph = useImportGraph
+			if err := testCleanup(t, &td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+func TestCleanupTestImports(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/file01_test.go", false},
+		{"host02/org02/repo02/file02.go", false},
+		{"host03/org03/repo03/file03.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host03/org03/repo03
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	maintestfile := `package main
+
+import (
+	_ "host02/org02/repo02"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/file01_test.go": `package repo01
+
+import (
+	_ "host03/org03/repo03"
+)
+`,
+	}
+
+	host01 := []FileInfo{
+		{"host01", true},
+		{"host01/org01", true},
+		{"host01/org01/repo01", true},
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/file01_test.go", false},
+	}
+	host02 := []FileInfo{
+		{"host02", true},
+		{"host02/org02", true},
+		{"host02/org02/repo02", true},
+		{"host02/org02/repo02/file02.go", false},
+	}
+	host03 := []FileInfo{
+		{"host03", true},
+		{"host03/org03", true},
+		{"host03/org03/repo03", true},
+		{"host03/org03/repo03/file03.go", false},
+	}
+
+	tests := map[string][]FileInfo{
+		testImportsKeep:       append(append(append([]FileInfo{}, host01...), host02...), host03...),
+		testImportsDrop:       host01,
+		testImportsOnlyDirect: append(append([]FileInfo{}, host01...), host02...),
+	}
+
+	for mode, expectedFiles := range tests {
+		for _, useLockFile := range []bool{false, true} {
+			for _, useImportGraph := range []bool{false, true} {
+				td := testData{
+					tree:     tree,
+					lockdata: lockdata,
+					mainfile: mainfile,
+					contents: contents,
+					prepare: func(dir string) error {
+						return ioutil.WriteFile(filepath.Join(dir, "main_test.go"), []byte(maintestfile), 0666)
+					},
+					expectedFiles: expectedFiles,
+					opts:          options{testImports: mode, useLockFile: useLockFile, useImportGraph: useImportGraph},
+				}
+				if err := testCleanup(t, &td); err != nil {
+					t.Fatalf("%s: unexpected error: %v", mode, err)
+				}
+			}
+		}
+	}
+}
+
+func TestCleanupNoTests(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/README", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/file01_test.go", false},
+		{"host01/org01/repo01/asm_test.s", false},
+		{"host01/org01/repo01/testdata/fixture.json", false},
+		{"host01/org01/repo01/testutil/util.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - testutil
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	contents := map[string]string{
+		"host01/org01/repo01/file01_test.go": `package repo01
+
+import (
+	_ "host01/org01/repo01/testutil"
+)
+`,
+	}
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		contents: contents,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/README", false},
+			{"host01/org01/repo01/file01.go", false},
+		},
+		opts: options{noTests: true},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		for _, useImportGraph := range []bool{false, true} {
+			td.opts.useLockFile = useLockFile
+			td.opts.useImportGraph = useImportGraph
+			if err := testCleanup(t, &td); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	// A project file that isn't valid go code doesn't break the test files
+	// removal
+	for _, useLockFile := range []bool{false, true} {
+		td := td
+		td.opts = options{noTests: true, useLockFile: useLockFile}
+		td.prepare = func(dir string) error {
+			if err := os.MkdirAll(filepath.Join(dir, "tmpl"), 0777); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, "tmpl", "gen.go"), []byte("package {{.Pkg}}\n"), 0666)
+		}
+		td.expectedFiles = []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/README", false},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/testutil", true},
+			{"host01/org01/repo01/testutil/util.go", false},
+		}
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The packages imported by the kept tests of a dependency are kept
+	for _, useLockFile := range []bool{false, true} {
+		td := td
+		td.opts = options{noTests: true, useLockFile: useLockFile}
+		td.config = `
+packages:
+  host01/org01/repo01:
+    noTests: false
+`
+		td.expectedFiles = []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/README", false},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/file01_test.go", false},
+			{"host01/org01/repo01/asm_test.s", false},
+			{"host01/org01/repo01/testutil", true},
+			{"host01/org01/repo01/testutil/util.go", false},
+		}
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCleanupVendorDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The project and the vendor dir live in different directories
+	projectDir := filepath.Join(tmpDir, "project")
+	depsDir := filepath.Join(tmpDir, "deps")
+	vendorDir := filepath.Join(depsDir, "vendor")
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	files := map[string]string{
+		"glide.yaml": "",
+		"glide.lock": lockdata,
+		"main.go":    "package main\n\nimport _ \"host01/org01/repo01\"\n",
+	}
+	if err := os.MkdirAll(projectDir, 0777); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(projectDir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := createVendorTree(t, depsDir, []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// glide list only knows the project vendor dir
+	opts = options{format: formatList, vendorDir: vendorDir}
+	if err := cleanup(ioutil.Discard, projectDir); err == nil {
+		t.Fatalf("expected error using glide list with another vendor dir")
+	}
+
+	opts = options{format: formatList, vendorDir: vendorDir, useImportGraph: true}
+	if err := cleanup(ioutil.Discard, projectDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkExpectedVendor(t, depsDir, []FileInfo{
+		{"host01", true},
+		{"host01/org01", true},
+		{"host01/org01/repo01", true},
+		{"host01/org01/repo01/file01.go", false},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// host02/org02/repo02 is locked but not inside the vendor dir anymore:
+	// cleaning again works and only reports it
+	oldLogger := logger
+	defer func() { logger = oldLogger }()
+	for _, o := range []options{
+		{format: formatList, vendorDir: vendorDir, useImportGraph: true},
+		{format: formatList, vendorDir: vendorDir, useLockFile: true},
+	} {
+		var buf bytes.Buffer
+		logger = &leveledLogger{w: &buf, level: levelWarn, format: logFormatText}
+		opts = o
+		if err := cleanup(ioutil.Discard, projectDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "missing=host02/org02/repo02") {
+			t.Fatalf("expected missing dependencies warning, got: %q", buf.String())
+		}
+	}
+}
+
+func testCleanup(t *testing.T, td *testData) error {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		return err
+	}
+	//defer os.RemoveAll(tmpDir)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(tmpDir); err != nil {
+		return fmt.Errorf("Could not change to dir %s: %v", wd, err)
+	}
+
+	// Create empty glide.yaml (currently not used for hash checking)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "glide.yaml"), nil, 0666); err != nil {
+		return fmt.Errorf("failed to create glide.yaml file: %v", err)
+	}
+
+	// Create glide.lock file
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "glide.lock"), []byte(td.lockdata), 0666); err != nil {
+		return fmt.Errorf("failed to create glide.lock file: %v", err)
+	}
+
+	// Create main.go file
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "main.go"), []byte(td.mainfile), 0666); err != nil {
+		return fmt.Errorf("failed to create main.go file: %v", err)
+	}
+
+	// Create glide-vc config file
+	if td.config != "" {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, configFile), []byte(td.config), 0666); err != nil {
+			return fmt.Errorf("failed to create %s file: %v", configFile, err)
+		}
+	}
+
+	if err := createVendorTree(t, tmpDir, td.tree); err != nil {
+		return err
+	}
+	for path, content := range td.contents {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "vendor", path), []byte(content), 0666); err != nil {
+			return fmt.Errorf("failed to write file %q: %v", path, err)
+		}
+	}
+
+	if td.gitInit {
+		for _, args := range [][]string{
+			{"init", "--quiet"},
+			{"add", "."},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "initial"},
+		} {
+			if _, err := git(tmpDir, nil, args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if td.prepare != nil {
+		if err := td.prepare(tmpDir); err != nil {
+			return err
+		}
+	}
+
+	opts = td.opts
+	if err := cleanup(os.Stdout, tmpDir); err != nil {
+		return err
+	}
+
+	if err := checkExpectedVendor(t, tmpDir, td.expectedFiles); err != nil {
+		return err
+	}
+	if td.expectedLockImports != nil {
+		lock, err := readLockFile(tmpDir)
+		if err != nil {
+			return err
+		}
+		imports := map[string][]string{}
+		for _, l := range lock.Imports {
+			imports[l.Name] = l.Subpackages
+		}
+		if !reflect.DeepEqual(imports, td.expectedLockImports) {
+			return fmt.Errorf("got lock imports %v, expected %v", imports, td.expectedLockImports)
+		}
+	}
+	if td.check != nil {
+		return td.check(tmpDir)
+	}
+	return nil
+}
+
+func TestGetLastVendorPath(t *testing.T) {
+	tests := map[string]string{
+		"host1/org1/repo1":                                                 "host1/org1/repo1",
+		"host1/org1/repo1/vendor/host2/org2/repo2":                         "host2/org2/repo2",
+		"host1/org1/repo1/vendor/host2/org2/repo2/vendor/host3/org3/repo3": "host3/org3/repo3",
+	}
+
+	for input, expected := range tests {
+		got, err := getLastVendorPath(filepath.FromSlash(input))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != filepath.FromSlash(expected) {
+			t.Fatalf("got=%q, expected=%q", got, expected)
+		}
+	}
+}
+
+func TestIsParentDirectory(t *testing.T) {
+	type testData2 struct {
+		Parent string
+		Child  string
+	}
+	tests := map[testData2]bool{
+		{"foo", "foo"}:     true,
+		{"foo", "foo/bar"}: true,
+		{"foo", "foobar"}:  false,
+		{"foo/", "foo"}:    true,
+		{"foo", "foo/"}:    true,
+		{"foo/", "foo/"}:   true,
+	}
+
+	for input, expected := range tests {
+		got := isParentDirectory(filepath.FromSlash(input.Parent), filepath.FromSlash(input.Child))
+		if got != expected {
+			t.Fatalf("got=%t, expected=%t", got, expected)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	conf := &config{
+		Packages: map[string]*packageConfig{
+			filepath.FromSlash("host1/org1"):       {},
+			filepath.FromSlash("host1/org1/repo1"): {},
+		},
+	}
+	tests := map[string]string{
+		"host1/org1/repo1/subpkg1": "host1/org1/repo1",
+		"host1/org1/repo1":         "host1/org1/repo1",
+		"host1/org1/repo11":        "host1/org1",
+		"host1/org2/repo1":         "",
+	}
+
+	for input, expected := range tests {
+		got, _ := conf.override(filepath.FromSlash(input))
+		if got != filepath.FromSlash(expected) {
+			t.Fatalf("got=%q, expected=%q", got, expected)
+		}
+	}
+}
+
+func TestSplitEmbedPatterns(t *testing.T) {
+	tests := map[string][]string{
+		"":                  nil,
+		" a b\tc":           {"a", "b", "c"},
+		` "a b" c`:          {"a b", "c"},
+		" `a b` \"c\\\"d\"": {"a b", `c"d`},
+	}
+
+	for input, expected := range tests {
+		got, err := splitEmbedPatterns(input)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("got=%q, expected=%q", got, expected)
+		}
+	}
+}
+
+func TestEmbedPatternsLongLine(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A line longer than the bufio.Scanner limit, like in go-bindata output
+	data := "package repo\n\nvar data = \"" + strings.Repeat("x", 70*1024) + "\"\n\n//go:embed assets\nvar assets string\n"
+	file := filepath.Join(tmpDir, "bindata.go")
+	if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	patterns, err := embedPatterns(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(patterns, []string{"assets"}) {
+		t.Fatalf("got=%q, expected=%q", patterns, []string{"assets"})
+	}
+}
+
+func TestCgoIncludesLongLine(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A line longer than the bufio.Scanner limit, like in amalgamated C
+	// sources
+	data := "static const char data[] = \"" + strings.Repeat("x", 70*1024) + "\";\n#include \"sqlite3.h\"\n"
+	file := filepath.Join(tmpDir, "sqlite3.c")
+	if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	includes, err := cgoIncludes(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(includes, []string{"sqlite3.h"}) {
+		t.Fatalf("got=%q, expected=%q", includes, []string{"sqlite3.h"})
+	}
+}
+
+func TestGoGenerateArgsLongLine(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	data := "// Code generated by go-bindata. DO NOT EDIT.\n\npackage repo\n\nvar data = \"" + strings.Repeat("x", 70*1024) + "\"\n\n//go:generate protoc api.proto\n"
+	file := filepath.Join(tmpDir, "bindata.go")
+	if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args, generated, err := goGenerateArgs(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !generated {
+		t.Fatalf("expected generated file")
+	}
+	if !reflect.DeepEqual(args, []string{"protoc", "api.proto"}) {
+		t.Fatalf("got=%q, expected=%q", args, []string{"protoc", "api.proto"})
+	}
+}
+
+func TestConfigTables(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	data := `
+codeSuffixes:
+  replace: [".go"]
+  extend: [".proto"]
+licenseFilePrefixes:
+  replace: []
+legalFileSubstrings:
+  extend: ["authors"]
+legalFilePatterns: ["^(?i)contributors(\\.md)?$"]
+`
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, configFile), []byte(data), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf, err := readConfig(tmpDir, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	codeTests := map[string]bool{
+		"file.go":    true,
+		"file.proto": true,
+		"file.c":     false,
+	}
+	for input, expected := range codeTests {
+		if got := conf.isCodeFile(input); got != expected {
+			t.Fatalf("%s: got=%t, expected=%t", input, got, expected)
+		}
+	}
+
+	legalTests := map[string]bool{
+		"LICENSE":         false,
+		"NOTICE":          true,
+		"AUTHORS":         true,
+		"CONTRIBUTORS.md": true,
+		"CONTRIBUTORS.go": false,
+	}
+	for input, expected := range legalTests {
+		if got := conf.isLegalFile(input); got != expected {
+			t.Fatalf("%s: got=%t, expected=%t", input, got, expected)
+		}
+	}
+}
+
+func TestSyncLockFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockdata := `hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - subpkg01
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	lockPath := filepath.Join(tmpDir, "glide.lock")
+	if err := ioutil.WriteFile(lockPath, []byte(lockdata), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Nothing pruned: the lock file is left untouched
+	var out bytes.Buffer
+	kept := map[string]struct{}{"host01/org01/repo01/subpkg01": {}, "host02/org02/repo02": {}}
+	changed, err := syncLockFile(&out, tmpDir, kept, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed || out.Len() != 0 || string(data) != lockdata {
+		t.Fatalf("unexpected lock file change (changed=%t), output:\n%s\nlock file:\n%s", changed, out.String(), data)
+	}
+
+	delete(kept, "host02/org02/repo02")
+	changed, err = syncLockFile(&out, tmpDir, kept, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed || !strings.Contains(out.String(), "Removing dependency from lock file: host02/org02/repo02") {
+		t.Fatalf("expected lock file change (changed=%t), output:\n%s", changed, out.String())
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	b := []string{"a", "c", "d", "e", "f", "g", "h", "i", "x", "j"}
+
+	tests := map[int][]string{
+		1: {"@@ -1,3 +1,2 @@", " a", "-b", " c", "@@ -9,2 +8,3 @@", " i", "+x", " j"},
+		3: {"@@ -1,5 +1,4 @@", " a", "-b", " c", " d", " e", "@@ -7,4 +6,5 @@", " g", " h", " i", "+x", " j"},
+		4: {"@@ -1,10 +1,10 @@", " a", "-b", " c", " d", " e", " f", " g", " h", " i", "+x", " j"},
+	}
+
+	for context, expected := range tests {
+		if got := unifiedDiff(a, b, context); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("context %d: got=%q, expected=%q", context, got, expected)
+		}
+	}
+}
+
+func TestPrintTree(t *testing.T) {
+	entries := []reportEntry{
+		{path: "host01", isDir: true},
+		{path: filepath.FromSlash("host01/file01.go")},
+		{path: filepath.FromSlash("host01/unused"), isDir: true, removed: true},
+		{path: filepath.FromSlash("host01/unused/file02.go"), removed: true},
+		{path: "host02", isDir: true, removed: true},
+		{path: filepath.FromSlash("host02/README"), removed: true},
+	}
+
+	tests := map[int]string{
+		0: `vendor
+├── host01/
+│   ├── file01.go
+│   └── unused/ [removed]
+│       └── file02.go [removed]
+└── host02/ [removed]
+    └── README [removed]
+`,
+		1: `vendor
+├── host01/ (2 files, 1 removed)
+└── host02/ [removed] (1 files, 1 removed)
+`,
+	}
+
+	for depth, expected := range tests {
+		var buf bytes.Buffer
+		printTree(&buf, "vendor", entries, depth)
+		if got := buf.String(); got != expected {
+			t.Fatalf("depth %d: got:\n%s\nexpected:\n%s", depth, got, expected)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	var rules ignoreRules
+	for _, line := range []string{"# comment", "", "*.sh", "!keep.sh", "docs/", "/host1/org1/repo1/assets"} {
+		rule, ok, err := parseIgnoreRule(line)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %d", len(rules))
+	}
+
+	type input struct {
+		path  string
+		isDir bool
+	}
+	tests := map[input]bool{
+		{"run.sh", false}:                           true,
+		{"host1/org1/repo1/run.sh", false}:          true,
+		{"host1/org1/repo1/keep.sh", false}:         false,
+		{"host1/org1/repo1/docs", true}:             true,
+		{"host1/org1/repo1/docs", false}:            false,
+		{"host1/org1/repo1/docs/index.md", false}:   true,
+		{"host1/org1/repo1/assets/logo.png", false}: true,
+		{"host2/host1/org1/repo1/assets", true}:     false,
+		{"host1/org1/repo1/file.go", false}:         false,
+	}
+	for in, expected := range tests {
+		got, err := rules.match(filepath.FromSlash(in.path), in.isDir)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", in, err)
+		}
+		if got != expected {
+			t.Fatalf("%v: got=%t, expected=%t", in, got, expected)
+		}
+	}
+}
+
+func TestWatchLoop(t *testing.T) {
+	events := make(chan fsnotify.Event)
+	errors := make(chan error)
+	runs := 0
+	done := make(chan error)
+	go func() {
+		done <- watchLoop(events, errors, 50*time.Millisecond, func() error {
+			runs++
+			return nil
+		})
+	}()
+
+	// A burst of events must trigger a single run
+	for i := 0; i < 5; i++ {
+		events <- fsnotify.Event{Name: "glide.lock", Op: fsnotify.Write}
+	}
+	// Chmod events are ignored
+	events <- fsnotify.Event{Name: "glide.lock", Op: fsnotify.Chmod}
+	time.Sleep(300 * time.Millisecond)
+	close(events)
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runs != 1 {
+		t.Fatalf("expected 1 run, got %d", runs)
+	}
+}
+
+func TestInstallHook(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glide-vc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gvcArgs := "glide-vc --only-code --keep='**/*.json'"
+
+	script := filepath.Join(tmpDir, "glide.sh")
+	for i := 0; i < 2; i++ {
+		if err := installScriptHook(script, gvcArgs); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), "exec "+gvcArgs+"\n") {
+		t.Fatalf("script doesn't run glide-vc:\n%s", data)
+	}
+	fi, err := os.Stat(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Mode()&0111 == 0 {
+		t.Fatalf("script isn't executable")
+	}
+
+	// A file not created by glide-vc must not be overwritten
+	other := filepath.Join(tmpDir, "other.sh")
+	if err := ioutil.WriteFile(other, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := installScriptHook(other, gvcArgs); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	makefile := filepath.Join(tmpDir, "Makefile")
+	if err := ioutil.WriteFile(makefile, []byte("all:\n\tgo build\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := installMakefileHook(makefile, gvcArgs); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	data, err = ioutil.ReadFile(makefile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "all:\n\tgo build\n\n"+hookBegin) {
+		t.Fatalf("existing Makefile content not preserved:\n%s", data)
+	}
+	if n := strings.Count(string(data), hookBegin); n != 1 {
+		t.Fatalf("expected 1 glide-vc block, got %d:\n%s", n, data)
+	}
+	if !strings.Contains(string(data), "\t"+gvcArgs+"\n") {
+		t.Fatalf("Makefile doesn't run glide-vc:\n%s", data)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"list":      "list",
+		"":          "''",
+		"**/*.json": "'**/*.json'",
+		"it's":      `'it'\''s'`,
+	}
+	for in, expected := range tests {
+		if got := shellQuote(in); got != expected {
+			t.Fatalf("%q: got=%q, expected=%q", in, got, expected)
+		}
+	}
+}
+
+func TestCleanupRecursive(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	files := map[string]string{
+		"glide.yaml":                        "excludeDirs:\n- excluded\n",
+		"glide.lock":                        lockdata,
+		"excluded/glide.yaml":               "",
+		"projects/p1/glide.yaml":            "",
+		"projects/p1/glide.lock":            lockdata,
+		"projects/p2/glide.yaml":            "",
+		"projects/p2/glide.lock":            "imports: [",
+		"projects/p3/glide.yaml":            "",
+		"projects/p1/vendor/x/y/glide.yaml": "",
+		".hidden/glide.yaml":                "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+	}
+	for _, dir := range []string{"", "projects/p1"} {
+		if err := createVendorTree(t, filepath.Join(tmpDir, dir), tree); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// p2 lock file is broken
+	if err := createVendorTree(t, filepath.Join(tmpDir, "projects/p2"), tree[1:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	projects, err := findProjects(tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, p := range projects {
+		got = append(got, filepath.ToSlash(relPath(tmpDir, p)))
+	}
+	expected := []string{".", "projects/p1", "projects/p2", "projects/p3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got projects %v, expected %v", got, expected)
+	}
+
+	opts = options{format: formatList, useLockFile: true}
+	var out bytes.Buffer
+	failed, err := cleanupRecursive(&out, tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failed != 1 {
+		t.Fatalf("expected 1 failed project, got %d:\n%s", failed, out.String())
+	}
+	for _, line := range []string{
+		"ok      .: removed 1 files, 14 bytes saved",
+		"ok      projects/p1: removed 2 files, 14 bytes saved",
+		"FAILED  projects/p2: ",
+		"skipped projects/p3: no vendor dir",
+		"4 projects, 1 failed",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("expected %q in output:\n%s", line, out.String())
+		}
+	}
+	for _, dir := range []string{"", "projects/p1"} {
+		if err := checkExpectedVendor(t, filepath.Join(tmpDir, dir), []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestExportVendor(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	files := map[string]string{
+		"glide.yaml": "",
+		"glide.lock": lockdata,
+		"main.go":    "package main\n\nimport _ \"host01/org01/repo01\"\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	tree := []FileInfo{
+		{"host01/org01/repo01/LICENSE", false},
+		{"host01/org01/repo01/README.md", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+	}
+	if err := createVendorTree(t, tmpDir, tree); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The kept symlinks are exported without following them
+	repo01 := filepath.Join(tmpDir, "vendor", "host01/org01/repo01")
+	if err := os.Mkdir(filepath.Join(repo01, "dir01"), 0777); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	symlinks := map[string]string{
+		"link01":   "dir01",
+		"broken01": "missing",
+	}
+	for name, target := range symlinks {
+		if err := os.Symlink(target, filepath.Join(repo01, name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	opts = options{format: formatList, onlyCode: true, useImportGraph: true, keepPatterns: []string{"**/link01", "**/broken01"}}
+	expected := []string{
+		"vendor/host01/",
+		"vendor/host01/org01/",
+		"vendor/host01/org01/repo01/",
+		"vendor/host01/org01/repo01/LICENSE",
+		"vendor/host01/org01/repo01/broken01",
+		"vendor/host01/org01/repo01/file01.go",
+		"vendor/host01/org01/repo01/link01",
+	}
+
+	// The archive must not depend on the files modification time
+	tgz := filepath.Join(tmpDir, "vendor.tgz")
+	var archives [][]byte
+	for i := 0; i < 2; i++ {
+		mtime := time.Now().Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(tmpDir, "vendor", "host01/org01/repo01/file01.go"), mtime, mtime); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := exportVendor(ioutil.Discard, tmpDir, tgz, archiveTarGz); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := ioutil.ReadFile(tgz)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		archives = append(archives, data)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Fatalf("archives are different")
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(archives[0]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if target, ok := symlinks[path.Base(hdr.Name)]; ok {
+			if hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != target {
+				t.Fatalf("got tar entry %s type %q linkname %q, expected symlink to %q", hdr.Name, hdr.Typeflag, hdr.Linkname, target)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got tar entries %v, expected %v", names, expected)
+	}
+
+	zipFile := filepath.Join(tmpDir, "vendor.zip")
+	if err := exportVendor(ioutil.Discard, tmpDir, zipFile, archiveZip); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer zr.Close()
+	names = nil
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if target, ok := symlinks[path.Base(f.Name)]; ok {
+			if f.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("got zip entry %s mode %v, expected a symlink", f.Name, f.Mode())
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != target {
+				t.Fatalf("got zip entry %s target %q, expected %q", f.Name, data, target)
+			}
+		}
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("got zip entries %v, expected %v", names, expected)
+	}
+
+	// The vendor dir must be untouched
+	for name, target := range symlinks {
+		link := filepath.Join(repo01, name)
+		if got, err := os.Readlink(link); err != nil || got != target {
+			t.Fatalf("got symlink %s target %q (err: %v), expected %q", name, got, err, target)
+		}
+		if err := os.Remove(link); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := checkExpectedVendor(t, tmpDir, append(tree,
+		FileInfo{"host01", true},
+		FileInfo{"host01/org01", true},
+		FileInfo{"host01/org01/repo01", true},
+		FileInfo{"host01/org01/repo01/dir01", true},
+		FileInfo{"host02", true},
+		FileInfo{"host02/org02", true},
+		FileInfo{"host02/org02/repo02", true},
+	)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCleanupOutputDir(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/LICENSE", false},
+		{"host01/org01/repo01/README.md", false},
+		{"host01/org01/repo01/file01.go", false},
+		{"host02/org02/repo02/file02.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	// The source vendor dir is untouched
+	expectedFiles := []FileInfo{
+		{"host01", true},
+		{"host01/org01", true},
+		{"host01/org01/repo01", true},
+		{"host02", true},
+		{"host02/org02", true},
+		{"host02/org02/repo02", true},
+	}
+	expectedFiles = append(expectedFiles, tree...)
+
+	td := testData{
+		tree:          tree,
+		lockdata:      lockdata,
+		expectedFiles: expectedFiles,
+		check: func(dir string) error {
+			if err := checkExpectedVendor(t, filepath.Join(dir, "pruned"), []FileInfo{
+				{"host01", true},
+				{"host01/org01", true},
+				{"host01/org01/repo01", true},
+				{"host01/org01/repo01/LICENSE", false},
+				{"host01/org01/repo01/file01.go", false},
+			}); err != nil {
+				return err
+			}
+			// The kept files are hard linked
+			src, err := os.Stat(filepath.Join(dir, "vendor/host01/org01/repo01/file01.go"))
+			if err != nil {
+				return err
+			}
+			dst, err := os.Stat(filepath.Join(dir, "pruned/vendor/host01/org01/repo01/file01.go"))
+			if err != nil {
+				return err
+			}
+			if !os.SameFile(src, dst) {
+				return fmt.Errorf("kept file not hard linked")
+			}
+			return nil
+		},
+		opts: options{onlyCode: true, useLockFile: true, outputDir: filepath.FromSlash("pruned/vendor")},
+	}
+
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The output dir must be empty
+	td.prepare = func(dir string) error {
+		return createVendorTree(t, filepath.Join(dir, "pruned"), tree[:1])
+	}
+	td.check = nil
+	if err := testCleanup(t, &td); err == nil || !strings.Contains(err.Error(), "is not empty") {
+		t.Fatalf("expected output dir not empty error, got: %v", err)
+	}
+}
+
+func TestCleanupSymlinks(t *testing.T) {
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host03/org03/repo03
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	for _, follow := range []bool{false, true} {
+		for _, format := range []string{formatList, formatTree, formatDiff} {
+			tmpDir, err := ioutil.TempDir("", "glidevc")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			files := map[string]string{
+				"glide.yaml":                      "",
+				"glide.lock":                      lockdata,
+				"outside/repo01/file01.go":        "package repo01",
+				"outside/repo01/README.md":        "",
+				"vendor/host02/org02/repo02/a":    "",
+				"vendor/host03/org03/repo03/b.go": "package repo03",
+			}
+			for name, content := range files {
+				path := filepath.Join(tmpDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			links := map[string]string{
+				// a needed package outside the vendor dir
+				"vendor/host01/org01/repo01": "../../../outside/repo01",
+				// a cycle
+				"vendor/host03/org03/repo03/loop":   "..",
+				"vendor/host03/org03/repo03/broken": "missing",
+			}
+			for name, target := range links {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0777); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := os.Symlink(filepath.FromSlash(target), filepath.Join(tmpDir, filepath.FromSlash(name))); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			opts = options{format: format, onlyCode: true, useLockFile: true, followSymlinks: follow}
+			var out bytes.Buffer
+			if err := cleanup(&out, tmpDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			reports := []string{
+				"Found broken symlink: host03/org03/repo03/broken -> missing",
+			}
+			if follow {
+				reports = append(reports,
+					"Following symlink: host01/org01/repo01 -> ../../../outside/repo01 (outside the vendor dir)",
+					"Not following symlink cycle: host03/org03/repo03/loop -> ..",
+				)
+			} else {
+				reports = append(reports,
+					"Found symlink: host01/org01/repo01 -> ../../../outside/repo01 (outside the vendor dir)",
+					"Found symlink: host03/org03/repo03/loop -> ..",
+				)
+			}
+			// The reports never read through the symlinks
+			switch format {
+			case formatTree:
+				reports = append(reports, "broken -> missing [removed]", "loop -> .. [removed]")
+			case formatDiff:
+				reports = append(reports, " vendor/host03/org03/repo03/broken | 1 -\n")
+			}
+			for _, report := range reports {
+				if !strings.Contains(out.String(), report) {
+					t.Fatalf("follow=%t format=%s: expected %q in output:\n%s", follow, format, report, out.String())
+				}
+			}
+
+			// The files outside the vendor dir are never removed
+			for _, name := range []string{"outside/repo01/file01.go", "outside/repo01/README.md"} {
+				if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+					t.Fatalf("follow=%t format=%s: unexpected error: %v", follow, format, err)
+				}
+			}
+
+			// Without following the symlinks the symlinked package isn't
+			// recognized and only the symlink is removed
+			expected := map[string]bool{
+				"vendor/host01/org01/repo01":        follow,
+				"vendor/host02":                     false,
+				"vendor/host03/org03/repo03/b.go":   true,
+				"vendor/host03/org03/repo03/loop":   false,
+				"vendor/host03/org03/repo03/broken": false,
+			}
+			for name, exists := range expected {
+				_, err := os.Lstat(filepath.Join(tmpDir, filepath.FromSlash(name)))
+				if exists && err != nil {
+					t.Fatalf("follow=%t format=%s: expected %s to exist: %v", follow, format, name, err)
+				}
+				if !exists && !os.IsNotExist(err) {
+					t.Fatalf("follow=%t format=%s: expected %s to be removed", follow, format, name)
+				}
+			}
+		}
+	}
+}
+
+func TestCheckRemovable(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	vpath := filepath.Join(tmpDir, "vendor")
+	for _, dir := range []string{vpath, filepath.Join(tmpDir, "outside")} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "outside"), filepath.Join(vpath, "link")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Removing the symlink is fine
+	if err := checkRemovable(vpath, filepath.Join(vpath, "link")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Removing a file through the symlink isn't
+	if err := checkRemovable(vpath, filepath.Join(vpath, "link", "file")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &leveledLogger{w: &buf, level: levelInfo, format: logFormatText}
+	l.debug("not logged")
+	l.info("resolved imports", "resolver", "lock-file", "packages", 3)
+	l.warn("phase finished", "phase", "resolve imports")
+	expected := "[INFO]\tresolved imports resolver=lock-file packages=3\n[WARN]\tphase finished phase=\"resolve imports\"\n"
+	if buf.String() != expected {
+		t.Fatalf("got %q, expected %q", buf.String(), expected)
+	}
+
+	buf.Reset()
+	l = &leveledLogger{w: &buf, level: levelError, format: logFormatJSON}
+	l.info("not logged")
+	l.err("failed", "err", fmt.Errorf("bad"), "count", 2)
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(entry, "time")
+	expectedEntry := map[string]interface{}{"level": "error", "msg": "failed", "err": "bad", "count": float64(2)}
+	if !reflect.DeepEqual(entry, expectedEntry) {
+		t.Fatalf("got %v, expected %v", entry, expectedEntry)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	permErr := &os.PathError{Op: "unlinkat", Path: "x", Err: os.ErrPermission}
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{fmt.Errorf("generic"), exitError},
+		{usageErrorf("unknown format %q", "bad"), exitUsage},
+		{&resolverError{resolver: "glide-list", err: fmt.Errorf("glide not found")}, exitResolver},
+		{vendorDirErrorf("cannot find vendor dir %s", "vendor"), exitVendorDir},
+		{&patternError{source: "--keep", pattern: "[a"}, exitPattern},
+		{&deleteError{notRemoved: []string{"a"}, errs: map[string]error{"a": permErr}}, exitPermission},
+		{&deleteError{notRemoved: []string{"a"}, errs: map[string]error{"a": fmt.Errorf("busy")}}, exitError},
+		{&deleteError{removed: []string{"b"}, notRemoved: []string{"a"}, errs: map[string]error{"a": permErr}}, exitPartialDelete},
+	}
+	for _, tt := range tests {
+		if code := exitCode(tt.err); code != tt.expected {
+			t.Errorf("%v: got exit code %d, expected %d", tt.err, code, tt.expected)
+		}
+	}
+}
+
+func TestCleanupPartialDelete(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"glide.yaml":                        "",
+		"glide.lock":                        lockdata,
+		"vendor/host01/org01/repo01/a.go":   "package repo01",
+		"vendor/host01/org01/repo01/a.md":   "",
+		"vendor/host02/org02/repo02/b.go":   "package repo02",
+		"vendor/host03/org03/repo03/c.go":   "package repo03",
+		"vendor/host03/org03/repo03/c.yaml": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Make the host01 and host03 repos read only
+	for _, dir := range []string{"vendor/host01/org01/repo01", "vendor/host03/org03"} {
+		path := filepath.Join(tmpDir, filepath.FromSlash(dir))
+		if err := os.Chmod(path, 0555); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer os.Chmod(path, 0777)
+	}
+
+	opts = options{format: formatList, onlyCode: true, useLockFile: true}
+	err = cleanup(ioutil.Discard, tmpDir)
+	derr, ok := err.(*deleteError)
+	if !ok {
+		t.Fatalf("expected a delete error, got: %v", err)
+	}
+	if code := exitCode(err); code != exitPartialDelete {
+		t.Fatalf("got exit code %d, expected %d", code, exitPartialDelete)
+	}
+
+	// The contents of host03/org03/repo03 are removed but not the
+	// directory, inside a read only directory
+	sep := string(os.PathSeparator)
+	repo03 := "host03" + sep + "org03" + sep + "repo03" + sep
+	expectedRemoved := []string{"host02", repo03 + "c.go", repo03 + "c.yaml"}
+	expectedNotRemoved := []string{"host01" + sep + "org01" + sep + "repo01" + sep + "a.md", "host03"}
+	if !reflect.DeepEqual(derr.removed, expectedRemoved) {
+		t.Fatalf("got removed %v, expected %v", derr.removed, expectedRemoved)
+	}
+	if !reflect.DeepEqual(derr.notRemoved, expectedNotRemoved) {
+		t.Fatalf("got not removed %v, expected %v", derr.notRemoved, expectedNotRemoved)
+	}
+	for _, p := range derr.notRemoved {
+		if _, err := os.Lstat(filepath.Join(tmpDir, "vendor", p)); err != nil {
+			t.Fatalf("expected %s to exist: %v", p, err)
+		}
+	}
+	for _, p := range derr.removed {
+		if _, err := os.Lstat(filepath.Join(tmpDir, "vendor", p)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", p)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := map[string]bool{
+		"**/*.json":         true,
+		"host1/*/repo?/a.c": true,
+		"[a-z]*.{go,c}":     true,
+		"[^a]":              true,
+		`\[literal\]`:       true,
+		`[\]]`:              true,
+		"a[":                false,
+		"a/b/[]":            false,
+		"[-a]":              false,
+		"[a-]":              false,
+		"[a--]":             false,
+		`a\`:                false,
+		"*.{go,c":           false,
+		`**/[\\]x`:          true,
+		`[a\\]`:             true,
+		`{a\\,b}`:           true,
+		`[\\\]]`:            true,
+		`[\\\]`:             false,
+		`{a\\}`:             true,
+		`{a\}`:              false,
+		`a\\\`:              false,
+		// alternatives and character classes can't span a '/'
+		"{assets/*,tmpl/*}": false,
+		"{assets,tmpl}/*":   true,
+		"[a/b]":             false,
+		`{a,b}/[\/]`:        true,
+		"{[a,b]}":           false,
+		"{a,{b,c}}":         true,
+	}
+	for pattern, valid := range tests {
+		err := validatePattern(pattern)
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", pattern, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected error", pattern)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	// The names reach every part of the patterns, so matchPattern reports
+	// an error exactly for the patterns rejected by validatePattern. The
+	// patterns without escaped backslashes are matched by doublestar.Match.
+	tests := []struct {
+		pattern string
+		name    string
+		valid   bool
+		match   bool
+	}{
+		{"**/*.json", "a/b/c.json", true, true},
+		{"[a-z]*.{go,c}", "x.c", true, true},
+		{"[^a]", "a", true, false},
+		{`\[literal\]`, "[literal]", true, true},
+		{`[\]]`, "]", true, true},
+		{"a[", "a[", false, false},
+		{"[a-]", "a", false, false},
+		{"[a--]", "a", false, false},
+		{`a\`, "ab", false, false},
+		{`a\\\`, `a\b`, false, false},
+		{"{a,b", "a", false, false},
+		{"{assets/*,tmpl/*}", "assets/a.json", false, false},
+		{"{assets,tmpl}/*", "tmpl/a.json", true, true},
+		{"a/[b/c]", "a/b/c", false, false},
+		{"{[a,b]}", "a", false, false},
+		// escaped backslashes
+		{`**/[\\]x`, `a/\x`, true, true},
+		{`[\\]x`, `\x`, true, true},
+		{`[^\\]x`, `\x`, true, false},
+		{`[^\\]x`, "ax", true, true},
+		{`[a\\]`, `\`, true, true},
+		{`[\\\]]`, "]", true, true},
+		{`[\\\]`, `\`, false, false},
+		{`{a\\,b}`, `a\`, true, true},
+		{`{a\\,b}`, "b", true, true},
+		{`{a\\}`, `a\`, true, true},
+		{`{a\}`, "a}", false, false},
+		{`a\\/b`, `a\/b`, true, true},
+		{`a\\\*`, `a\*`, true, true},
+		{`a\\\*`, `a\b`, true, false},
+		// ranges including the backslash
+		{"[!-~]", `\`, true, true},
+		{`[!-~]\\`, `\\`, true, true},
+		{`[\\-a]\\`, `]\`, true, true},
+		{`[\\-a]\\`, `[\`, true, false},
+		{`[Z-\\]\\`, `[\`, true, true},
+		{`[Z-\\]\\`, `]\`, true, false},
+	}
+	for _, tt := range tests {
+		if err := validatePattern(tt.pattern); (err == nil) != tt.valid {
+			t.Errorf("%s: got validation error %v, expected valid=%t", tt.pattern, err, tt.valid)
+		}
+		match, err := matchPattern(tt.pattern, tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s %s: got match error %v, expected valid=%t", tt.pattern, tt.name, err, tt.valid)
+			continue
+		}
+		if match != tt.match {
+			t.Errorf("%s %s: got match %t, expected %t", tt.pattern, tt.name, match, tt.match)
+		}
+		if !strings.Contains(tt.pattern, `\\`) {
+			if dmatch, derr := doublestar.Match(tt.pattern, tt.name); dmatch != match || (derr == nil) != (err == nil) {
+				t.Errorf("%s %s: got %t, %v, doublestar.Match returned %t, %v", tt.pattern, tt.name, match, err, dmatch, derr)
+			}
+		}
+	}
+}
+
+func TestValidatePatternRandom(t *testing.T) {
+	// The patterns accepted by validatePattern never fail at match time
+	const alphabet = `ab/*?[]{},\\^-`
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) string {
+		b := make([]byte, 1+rnd.Intn(n))
+		for i := range b {
+			b[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return string(b)
+	}
+	for i := 0; i < 100000; i++ {
+		pattern := random(10)
+		if validatePattern(pattern) != nil {
+			continue
+		}
+		for j := 0; j < 10; j++ {
+			name := random(8)
+			if _, err := matchPattern(pattern, name); err != nil {
+				t.Fatalf("valid pattern %q: unexpected error matching %q: %v", pattern, name, err)
+			}
+		}
+	}
+}
+
+func TestLoadPatterns(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "glidevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		configFile: `
+legalFilePatterns: ["(bad"]
+packages:
+  host1/org1/repo1:
+    keep: ["assets/**", "a["]
+`,
+		ignoreFile: "*.sh\n[z-\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	opts = options{keepPatterns: []string{"**/*.json", "{a,b"}}
+	_, _, err = loadPatterns(tmpDir)
+	errs, ok := err.(patternErrors)
+	if !ok {
+		t.Fatalf("expected pattern errors, got: %v", err)
+	}
+	if code := exitCode(err); code != exitPattern {
+		t.Fatalf("got exit code %d, expected %d", code, exitPattern)
+	}
+	var got []string
+	for _, pe := range errs {
+		got = append(got, pe.pattern)
+	}
+	expected := []string{"{a,b", "(bad", "a[", "**/[z-"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got invalid patterns %v, expected %v", got, expected)
+	}
+	if source := errs[3].source; source != ignoreFile+":2" {
+		t.Fatalf("got source %q, expected %q", source, ignoreFile+":2")
+	}
+}
+
+func TestCleanupKeepRules(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/data.json", false},
+		{"host01/org01/repo01/data_fixture.json", false},
+		{"host01/org01/repo01/VERSION", false},
+		{"host01/org01/repo01/assets/logo.png", false},
+		{"host01/org01/repo01/sub/VERSION", false},
+		{"host02/org02/repo02/assets/logo.png", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/data.json", false},
+			{"host01/org01/repo01/VERSION", false},
+			{"host01/org01/repo01/assets", true},
+			{"host01/org01/repo01/assets/logo.png", false},
+		},
+		opts: options{
+			onlyCode: true,
+			keepPatterns: []string{
+				"host01/org01/repo01:assets/**",
+				// host02 doesn't contain a needed package
+				"host02/org02/repo02:assets/**",
+				"**/*.json",
+				"!**/*_fixture.json",
+				":VERSION",
+			},
+		},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestParseKeepRule(t *testing.T) {
+	sep := string(os.PathSeparator)
+	tests := map[string]keepRule{
+		"**/*.json":                     {raw: "**/*.json", pattern: "**/*.json"},
+		"!**/*_fixture.json":            {raw: "!**/*_fixture.json", negate: true, pattern: "**/*_fixture.json"},
+		"github.com/foo/bar:assets/**":  {raw: "github.com/foo/bar:assets/**", scoped: true, scope: "github.com" + sep + "foo" + sep + "bar", pattern: "assets/**"},
+		"!github.com/foo/bar/:*.tar.gz": {raw: "!github.com/foo/bar/:*.tar.gz", negate: true, scoped: true, scope: "github.com" + sep + "foo" + sep + "bar", pattern: "*.tar.gz"},
+		":NOTICE":                       {raw: ":NOTICE", scoped: true, pattern: "NOTICE"},
+	}
+	for input, expected := range tests {
+		if rule := parseKeepRule(input); rule != expected {
+			t.Errorf("%s: got %+v, expected %+v", input, rule, expected)
+		}
+	}
+}
+
+func TestCleanupKeepRepo(t *testing.T) {
+	tree := []FileInfo{
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/file01_test.go", false},
+		{"host01/org01/repo01/data/schema.yaml", false},
+		{"host01/org01/repo01/cmd/tool/main.go", false},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/file03.go", false},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/README.md", false},
+		{"host02/org02/repo02/scripts/run.sh", false},
+		{"host04/org04/repo04/file04.go", false},
+	}
+
+	lockdata := `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+
+	mainfile := `package main
+
+import (
+	_ "host01/org01/repo01"
+)
+`
+
+	conf := `
+keepRepos:
+- host02/org02/repo02
+`
+
+	td := testData{
+		tree:     tree,
+		lockdata: lockdata,
+		mainfile: mainfile,
+		prepare: func(dir string) error {
+			return ioutil.WriteFile(filepath.Join(dir, configFile), []byte(conf), 0666)
+		},
+		expectedFiles: []FileInfo{
+			{"host01", true},
+			{"host01/org01", true},
+			{"host01/org01/repo01", true},
+			{"host01/org01/repo01/file01.go", false},
+			{"host01/org01/repo01/vendor", true},
+			{"host01/org01/repo01/vendor/host03", true},
+			{"host01/org01/repo01/vendor/host03/org03", true},
+			{"host01/org01/repo01/vendor/host03/org03/repo03", true},
+			{"host01/org01/repo01/vendor/host03/org03/repo03/file03.go", false},
+			{"host01/org01/repo01/vendor/host03/org03/repo03/README.md", false},
+			{"host02", true},
+			{"host02/org02", true},
+			{"host02/org02/repo02", true},
+			{"host02/org02/repo02/scripts", true},
+			{"host02/org02/repo02/scripts/run.sh", false},
+		},
+		opts: options{onlyCode: true, noTests: true, keepRepos: []string{"host03/org03/repo03"}},
+	}
+
+	for _, useLockFile := range []bool{false, true} {
+		td.opts.useLockFile = useLockFile
+		if err := testCleanup(t, &td); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The whole repository is kept at every vendor level
+	td.opts = options{onlyCode: true, noTests: true, keepRepos: []string{"host01/org01/repo01"}}
+	td.prepare = nil
+	td.expectedFiles = []FileInfo{
+		{"host01", true},
+		{"host01/org01", true},
+		{"host01/org01/repo01", true},
+		{"host01/org01/repo01/file01.go", false},
+		{"host01/org01/repo01/file01_test.go", false},
+		{"host01/org01/repo01/data", true},
+		{"host01/org01/repo01/data/schema.yaml", false},
+		{"host01/org01/repo01/cmd", true},
+		{"host01/org01/repo01/cmd/tool", true},
+		{"host01/org01/repo01/cmd/tool/main.go", false},
+		{"host01/org01/repo01/vendor", true},
+		{"host01/org01/repo01/vendor/host03", true},
+		{"host01/org01/repo01/vendor/host03/org03", true},
+		{"host01/org01/repo01/vendor/host03/org03/repo03", true},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/file03.go", false},
+		{"host01/org01/repo01/vendor/host03/org03/repo03/README.md", false},
+	}
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The kept repositories stay in the synced lock file
+	td.lockdata = `
+hash: 4e9eb8fc04548f539b83a52ce8c2001573802b21c903fca974442e79b4690713
+updated: 2016-03-04T15:02:44.735574617+01:00
+imports:
+- name: host01/org01/repo01
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+- name: host02/org02/repo02
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+  subpackages:
+  - scripts
+  - unused
+- name: host04/org04/repo04
+  version: 76626ae9c91c4f2a10f34cad8ce83ea42c93bb75
+devImports: []
+`
+	td.opts = options{onlyCode: true, noTests: true, useImportGraph: true, syncLock: true, keepRepos: []string{"host02/org02/repo02"}}
+	td.expectedFiles = []FileInfo{
+		{"host01", true},
+		{"host01/org01", true},
+		{"host01/org01/repo01", true},
+		{"host01/org01/repo01/file01.go", false},
+		{"host02", true},
+		{"host02/org02", true},
+		{"host02/org02/repo02", true},
+		{"host02/org02/repo02/scripts", true},
+		{"host02/org02/repo02/scripts/run.sh", false},
+	}
+	td.expectedLockImports = map[string][]string{
+		"host01/org01/repo01": nil,
+		"host02/org02/repo02": {"scripts"},
+	}
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A kept repository protected by the ignore file is found
+	oldLogger := logger
+	defer func() { logger = oldLogger }()
+	var buf bytes.Buffer
+	logger = &leveledLogger{w: &buf, level: levelWarn, format: logFormatText}
+	td.prepare = func(dir string) error {
+		return ioutil.WriteFile(filepath.Join(dir, ignoreFile), []byte("/host02/org02/repo02\n"), 0666)
+	}
+	td.opts = options{onlyCode: true, noTests: true, keepRepos: []string{"host02/org02/repo02"}}
+	td.lockdata = lockdata
+	td.expectedLockImports = nil
+	if err := testCleanup(t, &td); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "repository to keep not found") {
+		t.Fatalf("unexpected warning: %q", buf.String())
+	}
+}
+
+func TestDiffStatSummary(t *testing.T) {
+	tests := []struct {
+		files     int
+		deletions int
+		expected  string
+	}{
+		{0, 0, " 0 files changed"},
+		{1, 0, " 1 file changed, 0 insertions(+), 0 deletions(-)"},
+		{1, 1, " 1 file changed, 1 deletion(-)"},
+		{2, 0, " 2 files changed, 0 insertions(+), 0 deletions(-)"},
+		{2, 10, " 2 files changed, 10 deletions(-)"},
+	}
+	for _, tt := range tests {
+		if got := diffStatSummary(tt.files, tt.deletions); got != tt.expected {
+			t.Fatalf("files=%d deletions=%d: got %q, expected %q", tt.files, tt.deletions, got, tt.expected)
 		}
 	}
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	hookBegin = "# glide-vc hook begin"
+	hookEnd   = "# glide-vc hook end"
+)
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "manage the hooks running glide-vc after glide",
+}
+
+var hookInstallCmd = &cobra.Command{
+	Use:   "install [project dir]",
+	Short: "install a script (or Makefile targets) running glide-vc, with the provided flags, after glide install, update and get",
+	Run:   hookInstall,
+}
+
+var hookOpts struct {
+	script   string
+	makefile bool
+}
+
+func init() {
+	hookInstallCmd.Flags().StringVar(&hookOpts.script, "script", "glide.sh", "the wrapper script, relative to the project root, running glide and then glide-vc")
+	hookInstallCmd.Flags().BoolVar(&hookOpts.makefile, "makefile", false, "add glide-install, glide-update and glide-vc targets to the project Makefile instead of creating the wrapper script")
+	hookCmd.AddCommand(hookInstallCmd)
+	cmd.AddCommand(hookCmd)
+}
+
+func hookInstall(cmd *cobra.Command, args []string) {
+	if err := validateOptions(); err != nil {
+		fatal(err)
+	}
+
+	root, err := projectRoot(args)
+	if err != nil {
+		fatal(err)
+	}
+
+	gvcArgs := hookArgs(cmd.InheritedFlags())
+	if hookOpts.makefile {
+		err = installMakefileHook(filepath.Join(root, "Makefile"), gvcArgs)
+	} else {
+		err = installScriptHook(filepath.Join(root, hookOpts.script), gvcArgs)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// hookArgs returns the glide-vc command line, with the flags explicitly set,
+// that will be run by the hook.
+func hookArgs(flags *pflag.FlagSet) string {
+	args := []string{"glide-vc"}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if f.Value.Type() == "stringSlice" {
+			values, _ := flags.GetStringSlice(f.Name)
+			for _, v := range values {
+				args = append(args, "--"+f.Name+"="+shellQuote(v))
+			}
+			return
+		}
+		if f.Value.Type() == "bool" && f.Value.String() == "true" {
+			args = append(args, "--"+f.Name)
+			return
+		}
+		args = append(args, "--"+f.Name+"="+shellQuote(f.Value.String()))
+	})
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for the shell when needed.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,/:=") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// installScriptHook writes a wrapper script that runs glide with the provided
+// arguments and then, for the commands changing the vendor dir, glide-vc. An
+// existing file is overwritten only if it was generated by glide-vc.
+func installScriptHook(path, gvcArgs string) error {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if !strings.Contains(string(data), hookBegin) {
+			return fmt.Errorf("%s already exists and it wasn't created by glide-vc", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+%s
+# Runs glide and cleans the vendor dir after glide install, update and get.
+set -e
+glide "$@"
+for arg in "$@"; do
+	case "$arg" in
+	install|update|up|get)
+		exec %s
+		;;
+	esac
+done
+%s
+`, hookBegin, gvcArgs, hookEnd)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		return err
+	}
+	// WriteFile doesn't change the permissions of an existing file
+	if err := os.Chmod(path, 0755); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout(), "Installed glide wrapper script: %s\n", path)
+	return nil
+}
+
+// installMakefileHook adds to the Makefile (creating it if needed) the
+// targets running glide-vc after glide install and glide update. An existing
+// glide-vc block is replaced.
+func installMakefileHook(path, gvcArgs string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	block := fmt.Sprintf(`%s
+.PHONY: glide-install glide-update glide-vc
+glide-install:
+	glide install
+	$(MAKE) glide-vc
+glide-update:
+	glide update
+	$(MAKE) glide-vc
+glide-vc:
+	%s
+%s
+`, hookBegin, strings.Replace(gvcArgs, "$", "$$", -1), hookEnd)
+
+	content := string(data)
+	begin := strings.Index(content, hookBegin)
+	end := strings.Index(content, hookEnd)
+	switch {
+	case begin >= 0 && end > begin:
+		end += len(hookEnd)
+		if end < len(content) && content[end] == '\n' {
+			end++
+		}
+		content = content[:begin] + block + content[end:]
+	case content == "":
+		content = block
+	default:
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += "\n" + block
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout(), "Installed glide-vc targets in %s\n", path)
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile is the name of the file, in the project root, containing the
+// patterns of the vendor paths that must never be removed.
+const ignoreFile = ".glidevcignore"
+
+// ignoreRule is a .glidevcignore pattern
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the rules of a .glidevcignore file. They use the gitignore
+// syntax and are matched against the paths relative to the deeper vendor dir.
+type ignoreRules []ignoreRule
+
+// readIgnoreFile reads the ignore file inside the project path. A missing
+// file means no rules.
+func readIgnoreFile(path string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(path, ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		rules ignoreRules
+		errs  patternErrors
+	)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		rule, ok, err := parseIgnoreRule(scanner.Text())
+		if err != nil {
+			err.source = fmt.Sprintf("%s:%d", ignoreFile, n)
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rules, nil
+}
+
+// parseIgnoreRule parses a gitignore line. It returns false if the line
+// doesn't contain a rule.
+func parseIgnoreRule(line string) (ignoreRule, bool, *patternError) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	// Escaped leading characters
+	if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+	// A pattern without a slash matches at any level, otherwise it's
+	// relative to the vendor dir
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	if err := validatePattern(line); err != nil {
+		return rule, false, &patternError{pattern: line, err: err}
+	}
+	rule.pattern = line
+	return rule, true, nil
+}
+
+// match returns true if the path, or one of its parent directories, is
+// matched by the rules. Like in gitignore the last matching rule wins.
+func (r ignoreRules) match(path string, isDir bool) (bool, error) {
+	path = filepath.ToSlash(path)
+	matched := false
+	for _, rule := range r {
+		ok, err := rule.matchPath(path, isDir)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched = !rule.negate
+		}
+	}
+	return matched, nil
+}
+
+func (rule ignoreRule) matchPath(path string, isDir bool) (bool, error) {
+	for curpath := path; curpath != "."; curpath = filepath.ToSlash(filepath.Dir(curpath)) {
+		// parents are always directories
+		if !rule.dirOnly || isDir || curpath != path {
+			ok, err := matchPattern(rule.pattern, curpath)
+			if err != nil {
+				return false, &patternError{source: ignoreFile, pattern: rule.pattern, err: err}
+			}
+			if ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/glide/cfg"
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// Test imports modes
+const (
+	testImportsKeep       = "keep"
+	testImportsDrop       = "drop"
+	testImportsOnlyDirect = "only-direct"
+)
+
+// importGraphImports returns the vendored packages in the transitive closure
+// of the imports of the project go files. The project test files are
+// considered when withTests is true and the dependencies test files when
+// withDepTests is true.
+func importGraphImports(path, vpath string, withTests, withDepTests bool) ([]string, error) {
+	return importGraph(path, vpath, withTests, func(string) bool { return withDepTests })
+}
+
+// importGraph is like importGraphImports but the test files of the vendored
+// package at dir are considered only when depTests(dir) is true.
+func importGraph(path, vpath string, withTests bool, depTests func(dir string) bool) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	vpath, err = filepath.Abs(vpath)
+	if err != nil {
+		return nil, err
+	}
+
+	glideConfig, err := readGlideConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	excludeDirs := map[string]struct{}{}
+	for _, dir := range glideConfig.Exclude {
+		excludeDirs[filepath.Join(path, filepath.FromSlash(dir))] = struct{}{}
+	}
+
+	w := newImportWalker(path, vpath, depTests)
+
+	// Walk the project packages
+	err = filepath.Walk(path, func(curpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if curpath != path {
+			if _, ok := excludeDirs[curpath]; ok || curpath == vpath || info.Name() == gpath.VendorDir || skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+		}
+		imports, err := dirImports(curpath, withTests)
+		if err != nil {
+			return err
+		}
+		w.addImports(curpath, imports)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.walk(); err != nil {
+		return nil, err
+	}
+	return w.packages(vpath)
+}
+
+// toolImports returns the provided tool packages and the vendored packages
+// in the transitive closure of their imports.
+func toolImports(path, vpath string, tools []string) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	vpath, err = filepath.Abs(vpath)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newImportWalker(path, vpath, func(string) bool { return false })
+	for _, tool := range tools {
+		pkgDir := resolveVendorImport(path, vpath, path, tool)
+		if pkgDir == "" {
+			return nil, fmt.Errorf("cannot find tool package %q in vendor dir", tool)
+		}
+		w.addDir(pkgDir)
+	}
+	if err := w.walk(); err != nil {
+		return nil, err
+	}
+	return w.packages(vpath)
+}
+
+// importWalker computes the transitive closure of the imports of the
+// vendored packages.
+type importWalker struct {
+	root  string
+	vpath string
+	// consider also the test files of the vendored package at dir
+	withTests func(dir string) bool
+	// The vendored package dirs to visit
+	queue   []string
+	visited map[string]struct{}
+}
+
+func newImportWalker(root, vpath string, withTests func(dir string) bool) *importWalker {
+	return &importWalker{root: root, vpath: vpath, withTests: withTests, visited: map[string]struct{}{}}
+}
+
+// addImports adds the vendored packages imported from dir
+func (w *importWalker) addImports(dir string, imports []string) {
+	for _, imp := range imports {
+		if pkgDir := resolveVendorImport(w.root, w.vpath, dir, imp); pkgDir != "" {
+			w.addDir(pkgDir)
+		}
+	}
+}
+
+// addDir adds a vendored package dir
+func (w *importWalker) addDir(pkgDir string) {
+	if _, ok := w.visited[pkgDir]; !ok {
+		w.visited[pkgDir] = struct{}{}
+		w.queue = append(w.queue, pkgDir)
+	}
+}
+
+// walk visits the queued packages and all the packages they import
+func (w *importWalker) walk() error {
+	for len(w.queue) > 0 {
+		dir := w.queue[0]
+		w.queue = w.queue[1:]
+		imports, err := dirImports(dir, w.withTests(dir))
+		if err != nil {
+			return err
+		}
+		w.addImports(dir, imports)
+	}
+	return nil
+}
+
+// packages returns the import paths of the visited packages, relative to the
+// vendor dir that contains them.
+func (w *importWalker) packages(vpath string) ([]string, error) {
+	var packages []string
+	for dir := range w.visited {
+		localPath, err := filepath.Rel(vpath, dir)
+		if err != nil || strings.HasPrefix(localPath, "..") {
+			continue
+		}
+		pkg, err := getLastVendorPath(localPath)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, filepath.ToSlash(pkg))
+	}
+	return packages, nil
+}
+
+// applyTestImports adds or removes from packages the vendored packages
+// reachable only from test files according to the test imports mode:
+//
+// keep: keep the packages reachable from the project and dependencies tests
+// drop: remove the packages reachable only from tests
+// only-direct: keep only the packages reachable from the project tests
+func applyTestImports(path, vpath string, packages []string, mode string) ([]string, error) {
+	direct, indirect, err := testOnlyPackages(path, vpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var add, remove []string
+	switch mode {
+	case testImportsKeep:
+		add = append(direct, indirect...)
+	case testImportsDrop:
+		remove = append(direct, indirect...)
+	case testImportsOnlyDirect:
+		add, remove = direct, indirect
+	}
+
+	return append(removePackages(packages, remove), add...), nil
+}
+
+// testOnlyPackages returns the vendored packages reachable only from test
+// files: the ones reachable from the project tests and the ones reachable
+// only from the dependencies tests.
+func testOnlyPackages(path, vpath string) ([]string, []string, error) {
+	nonTest, err := importGraphImports(path, vpath, false, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	ownTest, err := importGraphImports(path, vpath, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	allTest, err := importGraphImports(path, vpath, true, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var direct, indirect []string
+	nonTestMap := stringSet(nonTest)
+	ownTestMap := stringSet(ownTest)
+	for _, pkg := range allTest {
+		if _, ok := nonTestMap[pkg]; ok {
+			continue
+		}
+		if _, ok := ownTestMap[pkg]; ok {
+			direct = append(direct, pkg)
+		} else {
+			indirect = append(indirect, pkg)
+		}
+	}
+	return direct, indirect, nil
+}
+
+// depTestOnlyPackages returns the vendored packages reachable only from the
+// dependencies tests that are removed. keepTests reports if the test files of
+// the vendored package at dir are kept: the packages reachable from them are
+// needed.
+func depTestOnlyPackages(path, vpath string, keepTests func(dir string) bool) ([]string, error) {
+	kept, err := importGraph(path, vpath, true, keepTests)
+	if err != nil {
+		return nil, err
+	}
+	allTest, err := importGraphImports(path, vpath, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return removePackages(allTest, kept), nil
+}
+
+// removePackages returns packages without the ones in remove
+func removePackages(packages, remove []string) []string {
+	removeMap := stringSet(remove)
+	var result []string
+	for _, pkg := range packages {
+		if _, ok := removeMap[pkg]; !ok {
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
+
+func stringSet(values []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// dirImports returns the imports of the go files inside dir.
+func dirImports(dir string, withTests bool) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var imports []string
+	fset := token.NewFileSet()
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".go") || skipDir(name) {
+			continue
+		}
+		if !withTests && strings.HasSuffix(name, goTestSuffix) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ImportsOnly)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range f.Imports {
+			p, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad import %s", filepath.Join(dir, name), imp.Path.Value)
+			}
+			imports = append(imports, p)
+		}
+	}
+	return imports, nil
+}
+
+// resolveVendorImport returns the directory of the vendored package imported
+// from dir, searching it in the nested vendor directories from dir up to the
+// project root (or up to the vendor dir for the vendored packages) like the go
+// tool does and then in the vendor dir. It returns an empty string if the
+// import isn't vendored.
+func resolveVendorImport(root, vpath, dir, imp string) string {
+	top := root
+	if isParentDirectory(vpath, dir) {
+		top = vpath
+	}
+	for curpath := dir; curpath != top && isParentDirectory(top, curpath); curpath = filepath.Dir(curpath) {
+		pkgDir := filepath.Join(curpath, gpath.VendorDir, filepath.FromSlash(imp))
+		if fi, err := os.Stat(pkgDir); err == nil && fi.IsDir() {
+			return pkgDir
+		}
+	}
+	pkgDir := filepath.Join(vpath, filepath.FromSlash(imp))
+	if fi, err := os.Stat(pkgDir); err == nil && fi.IsDir() {
+		return pkgDir
+	}
+	return ""
+}
+
+// skipDir returns true for the file and directory names ignored by the go tool
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata"
+}
+
+// pruneLockImports returns the lock imports that are also in the import
+// graph. It reports the locked packages that aren't imported anymore and
+// should be trimmed from the lock file. The root of a locked dependency is
+// reported only if it's a package with go files inside the vendor dir.
+func pruneLockImports(w io.Writer, vpath string, lock *cfg.Lockfile, graph []string) []string {
+	graphMap := stringSet(graph)
+
+	var imports []string
+	for _, l := range lockLocks(lock) {
+		var found, missing []string
+		for _, imp := range lockPackages(l) {
+			if _, ok := graphMap[imp]; ok {
+				found = append(found, imp)
+			} else {
+				missing = append(missing, imp)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(w, "Locked dependency not imported: %s\n", l.Name)
+			continue
+		}
+		for _, imp := range missing {
+			if imp == l.Name && !hasGoFiles(filepath.Join(vpath, filepath.FromSlash(imp))) {
+				continue
+			}
+			fmt.Fprintf(w, "Locked package not imported: %s\n", imp)
+		}
+		imports = append(imports, found...)
+	}
+	return imports
+}
+
+// hasGoFiles returns true if dir contains go files, excluding the test files
+// and the ones ignored by the go tool.
+func hasGoFiles(dir string) bool {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, goTestSuffix) && !skipDir(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readGlideConfig reads the glide.yaml inside path.
+func readGlideConfig(path string) (*cfg.Config, error) {
+	yml, err := ioutil.ReadFile(filepath.Join(path, gpath.GlideFile))
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ConfigFromYaml(yml)
+}
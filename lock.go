@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/glide/cfg"
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// syncLockFile rewrites the lock file reducing the subpackages to the kept
+// ones and removing the dependencies that have been completely removed. The
+// lock file changes are printed as a diff. When dryrun is true the lock file
+// isn't written. It returns true if the lock file changes.
+func syncLockFile(w io.Writer, path string, kept map[string]struct{}, dryrun bool) (bool, error) {
+	lockPath := filepath.Join(path, gpath.LockFile)
+	orig, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return false, err
+	}
+	lock, err := cfg.LockfileFromYaml(orig)
+	if err != nil {
+		return false, err
+	}
+
+	imports := syncLocks(w, lock.Imports, kept)
+	devImports := syncLocks(w, lock.DevImports, kept)
+	// Don't rewrite an unchanged lock file, marshaling it could still change
+	// its formatting
+	if locksEqual(lock.Imports, imports) && locksEqual(lock.DevImports, devImports) {
+		return false, nil
+	}
+	lock.Imports, lock.DevImports = imports, devImports
+
+	data, err := lock.Marshal()
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(w, "--- a/%s\n+++ b/%s\n", gpath.LockFile, gpath.LockFile)
+	for _, line := range unifiedDiff(splitLines(string(orig)), splitLines(string(data)), 3) {
+		fmt.Fprintln(w, line)
+	}
+
+	if dryrun {
+		return true, nil
+	}
+	return true, lock.WriteFile(lockPath)
+}
+
+// syncLocks returns the locks of the kept dependencies with only the kept
+// subpackages.
+func syncLocks(w io.Writer, locks cfg.Locks, kept map[string]struct{}) cfg.Locks {
+	var newLocks cfg.Locks
+	for _, l := range locks {
+		var subpackages []string
+		for _, subpackage := range l.Subpackages {
+			if _, ok := kept[l.Name+"/"+subpackage]; ok {
+				subpackages = append(subpackages, subpackage)
+			}
+		}
+		if _, ok := kept[l.Name]; !ok && len(subpackages) == 0 {
+			fmt.Fprintf(w, "Removing dependency from lock file: %s\n", l.Name)
+			continue
+		}
+		nl := l.Clone()
+		nl.Subpackages = subpackages
+		newLocks = append(newLocks, nl)
+	}
+	return newLocks
+}
+
+// locksEqual returns true if the locks have the same dependencies with the
+// same subpackages. syncLocks changes nothing else.
+func locksEqual(a, b cfg.Locks) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || len(a[i].Subpackages) != len(b[i].Subpackages) {
+			return false
+		}
+		for j := range a[i].Subpackages {
+			if a[i].Subpackages[j] != b[i].Subpackages[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff returns the unified diff hunks, with the provided number of
+// context lines, to transform a into b.
+func unifiedDiff(a, b []string, context int) []string {
+	// Compute the longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Generate the edit script
+	type edit struct {
+		op   byte
+		line string
+		// line numbers (0 based) in a and b before this edit
+		ai, bi int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	// Group the changes in hunks
+	var out []string
+	for start := 0; start < len(edits); {
+		// Find next change
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		hstart := start - context
+		if hstart < 0 {
+			hstart = 0
+		}
+		// Extend the hunk while changes are within 2*context lines
+		end := start
+		for k := start; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				end = k
+			} else if k-end > 2*context {
+				break
+			}
+		}
+		hend := end + context + 1
+		if hend > len(edits) {
+			hend = len(edits)
+		}
+
+		var alines, blines int
+		for _, e := range edits[hstart:hend] {
+			if e.op != '+' {
+				alines++
+			}
+			if e.op != '-' {
+				blines++
+			}
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", edits[hstart].ai+1, alines, edits[hstart].bi+1, blines))
+		for _, e := range edits[hstart:hend] {
+			out = append(out, string(e.op)+e.line)
+		}
+		start = hend
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Log levels
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelError:
+		return "error"
+	case levelWarn:
+		return "warn"
+	case levelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// leveledLogger writes leveled messages with key value fields, as text or
+// json lines. It's safe for concurrent use.
+type leveledLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  logLevel
+	format string
+}
+
+var logger = &leveledLogger{w: os.Stderr, level: levelWarn, format: logFormatText}
+
+var logOpts struct {
+	verbose int
+	quiet   bool
+	format  string
+}
+
+func init() {
+	cmd.PersistentFlags().CountVarP(&logOpts.verbose, "verbose", "v", "log the resolver, the number of resolved packages and the phases timing (-v) and also the decision for every vendor directory (-vv)")
+	cmd.PersistentFlags().BoolVarP(&logOpts.quiet, "quiet", "q", false, "print only the errors")
+	cmd.PersistentFlags().StringVar(&logOpts.format, "log-format", logFormatText, "log format: text or json")
+}
+
+// setupLog configures the logger from the command line options
+func setupLog() error {
+	switch logOpts.format {
+	case logFormatText, logFormatJSON:
+	default:
+		return usageErrorf("unknown log format %q", logOpts.format)
+	}
+	logger.format = logOpts.format
+
+	switch {
+	case logOpts.quiet:
+		logger.level = levelError
+	case logOpts.verbose == 1:
+		logger.level = levelInfo
+	case logOpts.verbose > 1:
+		logger.level = levelDebug
+	}
+	return nil
+}
+
+// stdout returns the writer for the commands output, discarding it in quiet
+// mode.
+func stdout() io.Writer {
+	if logOpts.quiet {
+		return ioutil.Discard
+	}
+	return os.Stdout
+}
+
+func (l *leveledLogger) err(msg string, fields ...interface{})   { l.log(levelError, msg, fields) }
+func (l *leveledLogger) warn(msg string, fields ...interface{})  { l.log(levelWarn, msg, fields) }
+func (l *leveledLogger) info(msg string, fields ...interface{})  { l.log(levelInfo, msg, fields) }
+func (l *leveledLogger) debug(msg string, fields ...interface{}) { l.log(levelDebug, msg, fields) }
+
+// enabled returns true if the messages of the provided level are logged
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+// phase logs the start of a phase and returns a function that logs its
+// duration.
+func (l *leveledLogger) phase(name string, fields ...interface{}) func() {
+	start := time.Now()
+	l.debug("phase started", append([]interface{}{"phase", name}, fields...)...)
+	return func() {
+		l.info("phase finished", append([]interface{}{"phase", name, "duration", time.Since(start).String()}, fields...)...)
+	}
+}
+
+// log writes a message. fields are key value pairs.
+func (l *leveledLogger) log(level logLevel, msg string, fields []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	var line []byte
+	if l.format == logFormatJSON {
+		entry := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339Nano),
+			"level": level.String(),
+			"msg":   msg,
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := fields[i+1]
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			entry[fmt.Sprint(fields[i])] = value
+		}
+		line, _ = json.Marshal(entry)
+	} else {
+		parts := []string{fmt.Sprintf("[%s]\t%s", strings.ToUpper(level.String()), msg)}
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := fmt.Sprint(fields[i+1])
+			if strings.ContainsAny(value, " \t\"=") {
+				value = strconv.Quote(value)
+			}
+			parts = append(parts, fmt.Sprintf("%v=%s", fields[i], value))
+		}
+		line = []byte(strings.Join(parts, " "))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+// fatal logs the error and exits with the error exit code
+func fatal(err error) {
+	code := exitCode(err)
+	if logger.format == logFormatJSON {
+		logger.err(err.Error(), "exitCode", code)
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Masterminds/glide/cfg"
+	"github.com/bmatcuk/doublestar"
+	"github.com/spf13/cobra"
+)
+
+var patternsCmd = &cobra.Command{
+	Use:   "patterns",
+	Short: "work with the keep patterns",
+}
+
+var patternsTestCmd = &cobra.Command{
+	Use:   "test <pattern> <path>...",
+	Short: "print which of the provided paths, relative to the vendor dir, are matched by a keep pattern",
+	Run:   patternsTest,
+}
+
+func init() {
+	patternsCmd.AddCommand(patternsTestCmd)
+	cmd.AddCommand(patternsCmd)
+}
+
+func patternsTest(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fatal(usageErrorf("a pattern and at least one path must be provided"))
+	}
+	rule, paths := parseKeepRule(args[0]), args[1:]
+	if err := validatePattern(rule.pattern); err != nil {
+		fatal(&patternError{pattern: rule.raw, err: err})
+	}
+
+	var roots []string
+	if rule.anyDep() {
+		root, err := projectRoot(nil)
+		if err != nil {
+			fatal(err)
+		}
+		if roots, err = lockRoots(root); err != nil {
+			fatal(err)
+		}
+	}
+
+	for _, path := range paths {
+		// Consider the path inside a needed package
+		localPath := filepath.FromSlash(path)
+		ok, err := rule.match(localPath, true, []string{filepath.Dir(localPath)}, roots)
+		if err != nil {
+			fatal(err)
+		}
+		switch {
+		case !ok:
+			fmt.Fprintf(stdout(), "no match  %s\n", path)
+		case rule.negate:
+			fmt.Fprintf(stdout(), "excluded  %s\n", path)
+		default:
+			fmt.Fprintf(stdout(), "match     %s\n", path)
+		}
+	}
+}
+
+// keepRule is a keep pattern. Its syntax is [!][scope:]pattern.
+//
+// Without a scope the pattern is matched against the paths, relative to the
+// deeper vendor dir, of the files inside the needed packages. With a scope,
+// the import path of a dependency, it's matched against the paths relative
+// to the dependency root of all the dependency files. An empty scope means
+// the root of every locked dependency. Like the per dependency overrides, a
+// scope only applies if the dependency contains a needed package.
+//
+// A leading ! negates the pattern.
+type keepRule struct {
+	raw     string
+	negate  bool
+	scoped  bool
+	scope   string
+	pattern string
+}
+
+func parseKeepRule(s string) keepRule {
+	rule := keepRule{raw: s}
+	if strings.HasPrefix(s, "!") {
+		rule.negate = true
+		s = s[1:]
+	}
+	if i := strings.Index(s, ":"); i != -1 {
+		rule.scoped = true
+		rule.scope = filepath.FromSlash(strings.Trim(s[:i], "/"))
+		s = s[i+1:]
+	}
+	rule.pattern = s
+	return rule
+}
+
+// anyDep returns true if the rule is relative to the root of every
+// dependency
+func (rule keepRule) anyDep() bool {
+	return rule.scoped && rule.scope == ""
+}
+
+// match returns true if the rule pattern, ignoring the negation, matches the
+// file at path, relative to the deeper vendor dir. inPackage reports if the
+// file is inside a needed package. roots are the roots of the locked
+// dependencies.
+func (rule keepRule) match(path string, inPackage bool, pkgList, roots []string) (bool, error) {
+	var base string
+	switch {
+	case !rule.scoped:
+		if !inPackage {
+			return false, nil
+		}
+	case rule.anyDep():
+		for _, root := range roots {
+			if isParentDirectory(root, path) && len(root) > len(base) {
+				base = root
+			}
+		}
+		if base == "" {
+			return false, nil
+		}
+	default:
+		if !isParentDirectory(rule.scope, path) {
+			return false, nil
+		}
+		base = rule.scope
+	}
+	if base != "" && !containsPackage(base, pkgList) {
+		return false, nil
+	}
+
+	relPath := path
+	if base != "" {
+		var err error
+		if relPath, err = filepath.Rel(base, path); err != nil {
+			return false, nil
+		}
+	}
+	ok, err := matchPattern(rule.pattern, filepath.ToSlash(relPath))
+	if err != nil {
+		return false, &patternError{pattern: rule.raw, err: err}
+	}
+	return ok, nil
+}
+
+// keepRules are keep patterns evaluated in order: the last one matching a
+// file decides if it's kept, so a negated pattern excludes the files matched
+// by the previous ones.
+type keepRules []keepRule
+
+func parseKeepRules(patterns []string) keepRules {
+	var rules keepRules
+	for _, pattern := range patterns {
+		rules = append(rules, parseKeepRule(pattern))
+	}
+	return rules
+}
+
+// scopedKeepRules returns the keep rules, without a scope syntax, relative
+// to the dependency at prefix.
+func scopedKeepRules(prefix string, patterns []string) keepRules {
+	var rules keepRules
+	for _, pattern := range patterns {
+		rule := keepRule{raw: pattern, scoped: true, scope: prefix, pattern: pattern}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			rule.pattern = pattern[1:]
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match returns true if the rules keep the file at path. See keepRule.match.
+func (r keepRules) match(path string, inPackage bool, pkgList, roots []string) (bool, error) {
+	keep := false
+	for _, rule := range r {
+		ok, err := rule.match(path, inPackage, pkgList, roots)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			keep = !rule.negate
+		}
+	}
+	return keep, nil
+}
+
+// anyDep returns true if some rules are relative to the root of every
+// dependency
+func (r keepRules) anyDep() bool {
+	for _, rule := range r {
+		if rule.anyDep() {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the invalid patterns
+func (r keepRules) check(source string) patternErrors {
+	var errs patternErrors
+	for _, rule := range r {
+		if err := validatePattern(rule.pattern); err != nil {
+			errs = append(errs, &patternError{source: source, pattern: rule.raw, err: err})
+		}
+	}
+	return errs
+}
+
+// containsPackage returns true if dir is, or contains, one of the packages
+func containsPackage(dir string, pkgList []string) bool {
+	for _, name := range pkgList {
+		if isParentDirectory(dir, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockRoots returns the roots of the dependencies locked in the project lock
+// file.
+func lockRoots(path string) ([]string, error) {
+	lock, err := readLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, l := range append(append(cfg.Locks{}, lock.Imports...), lock.DevImports...) {
+		roots = append(roots, filepath.FromSlash(l.Name))
+	}
+	return roots, nil
+}
+
+// loadPatterns reads the configuration file and the ignore file inside the
+// project path and validates all the patterns, from them and from the
+// options, before they are used. All the invalid patterns are reported
+// together.
+func loadPatterns(path string) (*config, ignoreRules, error) {
+	errs := parseKeepRules(opts.keepPatterns).check("--keep")
+
+	conf, err := readConfig(path, opts.configFile)
+	if perrs, ok := err.(patternErrors); ok {
+		errs = append(errs, perrs...)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	rules, err := readIgnoreFile(path)
+	if perrs, ok := err.(patternErrors); ok {
+		errs = append(errs, perrs...)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return conf, rules, nil
+}
+
+// validatePattern returns an error if pattern isn't a valid doublestar
+// pattern. matchPattern reports a bad pattern only when the match reaches its
+// malformed part, so the whole pattern is checked here with the same syntax
+// rules. Like doublestar, the pattern is split on '/' before parsing its
+// components, so a character class or alternatives can't contain a '/'.
+func validatePattern(pattern string) error {
+	for _, component := range splitUnescaped(pattern, '/') {
+		if err := validateComponent(component); err != nil {
+			if component != pattern {
+				return fmt.Errorf("%v in path component %q", err, component)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// validateComponent checks a path component of a pattern
+func validateComponent(component string) error {
+	for i := 0; i < len(component); i++ {
+		switch component[i] {
+		case '\\':
+			if i++; i >= len(component) {
+				return errors.New("trailing backslash")
+			}
+		case '[':
+			end := indexUnescaped(component[i+1:], ']')
+			if end == -1 {
+				return errors.New("missing ']'")
+			}
+			if err := validateClass(component[i+1 : i+1+end]); err != nil {
+				return err
+			}
+			i += end + 1
+		case '{':
+			end := indexUnescaped(component[i+1:], '}')
+			if end == -1 {
+				return errors.New("missing '}'")
+			}
+			// Like doublestar, every alternative is checked followed by
+			// the rest of the component
+			rest := component[i+end+2:]
+			for _, alt := range splitUnescaped(component[i+1:i+1+end], ',') {
+				if err := validateComponent(alt + rest); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// validateClass checks the content of a character class
+func validateClass(class string) error {
+	if class == "" {
+		return errors.New("empty character class")
+	}
+	runes := []rune(class)
+	i := 0
+	if runes[0] == '^' {
+		i++
+	}
+	// next returns the next range character, handling the escapes
+	next := func() (rune, error) {
+		c := runes[i]
+		i++
+		if c == '\\' {
+			if i >= len(runes) {
+				return 0, errors.New("trailing backslash in character class")
+			}
+			c = runes[i]
+			i++
+		}
+		return c, nil
+	}
+	for i < len(runes) {
+		if runes[i] == '-' {
+			return errors.New("bad character range")
+		}
+		if _, err := next(); err != nil {
+			return err
+		}
+		if i < len(runes) && runes[i] == '-' {
+			if i++; i >= len(runes) || runes[i] == '-' {
+				return errors.New("bad character range")
+			}
+			if _, err := next(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexUnescaped returns the index of the first r in s not escaped by a
+// backslash, or -1. A backslash escapes the following character, so r is
+// escaped only when preceded by an odd number of consecutive backslashes.
+func indexUnescaped(s string, r rune) int {
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == r:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits s around the r not escaped by a backslash
+func splitUnescaped(s string, r rune) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, r)
+		if i == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+utf8.RuneLen(r):]
+	}
+}
+
+// backslashRune replaces the backslashes of the matched paths, and the
+// escaped ones of the patterns, when a pattern contains an escaped backslash.
+// doublestar checks only the previous character to know if a ']', '}', ',' or
+// '/' is escaped, so it considers escaped the ones following an escaped
+// backslash.
+const backslashRune = '\uFFFF'
+
+// matchPattern returns true if name matches pattern like doublestar.Match
+// does, but correctly handling the escaped backslashes.
+func matchPattern(pattern, name string) (bool, error) {
+	if !strings.Contains(pattern, `\\`) {
+		return doublestar.Match(pattern, name)
+	}
+	return doublestar.Match(replaceBackslashes(pattern), strings.Replace(name, `\`, string(backslashRune), -1))
+}
+
+// replaceBackslashes replaces the escaped backslashes of pattern with
+// backslashRune. The character ranges including the backslash are changed to
+// also include backslashRune.
+func replaceBackslashes(pattern string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 >= len(pattern) {
+				b.WriteByte('\\')
+				continue
+			}
+			if i++; pattern[i] == '\\' {
+				b.WriteRune(backslashRune)
+			} else {
+				b.WriteByte('\\')
+				b.WriteByte(pattern[i])
+			}
+		case '[':
+			end := indexUnescaped(pattern[i+1:], ']')
+			if end == -1 {
+				b.WriteString(pattern[i:])
+				return b.String()
+			}
+			b.WriteByte('[')
+			b.WriteString(replaceClassBackslashes(pattern[i+1 : i+1+end]))
+			b.WriteByte(']')
+			i += end + 1
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// replaceClassBackslashes replaces the backslashes in the content of a valid
+// character class. Only the ranges including the backslash are changed, the
+// rest is kept as it is since doublestar could parse it differently (like a
+// class containing the end of some alternatives).
+func replaceClassBackslashes(class string) string {
+	var b bytes.Buffer
+	runes := []rune(class)
+	i := 0
+	if len(runes) > 0 && runes[0] == '^' {
+		b.WriteRune('^')
+		i++
+	}
+	// next returns the next range character and its raw text
+	next := func() (rune, string) {
+		start := i
+		c := runes[i]
+		if i++; c == '\\' && i < len(runes) {
+			c = runes[i]
+			i++
+		}
+		return c, string(runes[start:i])
+	}
+	for i < len(runes) {
+		start := i
+		lo, loText := next()
+		hi, hiText := lo, loText
+		if i+1 < len(runes) && runes[i] == '-' {
+			i++
+			hi, hiText = next()
+		}
+		switch {
+		case lo > hi && (lo == '\\' || hi == '\\'):
+			// An empty range, keep it without the backslash
+			b.WriteString("b-a")
+			continue
+		case lo > '\\' || '\\' > hi:
+			// Keep the other ranges as they are
+			b.WriteString(string(runes[start:i]))
+			continue
+		}
+		b.WriteRune(backslashRune)
+		// Exclude the backslash from the range
+		if lo == '\\' {
+			lo, loText = ']', `\]`
+		}
+		if hi == '\\' {
+			hi, hiText = '[', "["
+		}
+		if lo > hi {
+			continue
+		}
+		b.WriteString(loText)
+		if hi != lo {
+			b.WriteString("-" + hiText)
+		}
+	}
+	return b.String()
+}
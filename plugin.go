@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// glideEnv applies the environment glide passes to its plugins when glide-vc
+// is run as `glide vc`: GLIDE_YAML is the name of the glide file (the glide
+// --yaml option).
+func glideEnv() {
+	if yaml := os.Getenv("GLIDE_YAML"); yaml != "" {
+		gpath.GlideFile = yaml
+	}
+}
+
+// projectRoot returns the absolute path of the project dir provided in the
+// command arguments, that must contain the glide file. Without arguments it
+// searches the glide file starting from the working dir and going up like
+// glide does.
+func projectRoot(args []string) (string, error) {
+	switch len(args) {
+	case 0:
+	case 1:
+		root, err := filepath.Abs(args[0])
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(root, gpath.GlideFile)); err != nil {
+			return "", fmt.Errorf("cannot find %s in project dir %s", gpath.GlideFile, root)
+		}
+		return root, nil
+	default:
+		return "", usageErrorf("only one project dir can be provided")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	root, err := gpath.GlideWD(cwd)
+	if err != nil {
+		return "", fmt.Errorf("cannot find %s in %s or in its parent directories", gpath.GlideFile, cwd)
+	}
+	return root, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// projectResult is the result of the cleanup of a project
+type projectResult struct {
+	path   string
+	output bytes.Buffer
+	// removed files and saved bytes
+	files int
+	size  int64
+	// the project doesn't have a vendor dir
+	skipped bool
+	err     error
+}
+
+// findProjects returns the directories, under root, containing a glide file.
+// The vendor dirs, the directories ignored by the go tool and the
+// directories excluded by the glide file of a parent project are skipped.
+func findProjects(root string) ([]string, error) {
+	var projects []string
+	excludeDirs := map[string]struct{}{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root {
+			if _, ok := excludeDirs[path]; ok || info.Name() == gpath.VendorDir || skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+		}
+		if _, err := os.Stat(filepath.Join(path, gpath.GlideFile)); err != nil {
+			return nil
+		}
+		glideConfig, err := readGlideConfig(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, dir := range glideConfig.Exclude {
+			excludeDirs[filepath.Join(path, filepath.FromSlash(dir))] = struct{}{}
+		}
+		projects = append(projects, path)
+		return nil
+	})
+	return projects, err
+}
+
+// cleanupProjects cleans the projects using the provided number of parallel
+// jobs. The results are in the same order of the projects.
+func cleanupProjects(projects []string, jobs int) []*projectResult {
+	results := make([]*projectResult, len(projects))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range work {
+				results[n] = cleanupProject(projects[n])
+			}
+		}()
+	}
+	for n := range projects {
+		work <- n
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// cleanupProject cleans a project saving its output
+func cleanupProject(path string) *projectResult {
+	r := &projectResult{path: path}
+	vpath, err := vendorPath(path)
+	if err != nil {
+		r.skipped = true
+		return r
+	}
+	files, size, err := diskUsage(vpath)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	if err := cleanup(&r.output, path); err != nil {
+		r.err = err
+		return r
+	}
+	newFiles, newSize, err := diskUsage(vpath)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.files, r.size = files-newFiles, size-newSize
+	return r
+}
+
+// printResults prints the output of every project followed by a summary of
+// the results. It returns the number of failed projects.
+func printResults(w io.Writer, root string, results []*projectResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.skipped {
+			continue
+		}
+		fmt.Fprintf(w, "==> %s\n", relPath(root, r.path))
+		w.Write(r.output.Bytes())
+		if r.err != nil {
+			fmt.Fprintf(w, "error: %v\n", r.err)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, r := range results {
+		name := relPath(root, r.path)
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(w, "FAILED  %s: %s\n", name, strings.SplitN(r.err.Error(), "\n", 2)[0])
+		case r.skipped:
+			fmt.Fprintf(w, "skipped %s: no vendor dir\n", name)
+		case opts.dryrun:
+			fmt.Fprintf(w, "ok      %s\n", name)
+		default:
+			fmt.Fprintf(w, "ok      %s: removed %d files, %d bytes saved\n", name, r.files, r.size)
+		}
+	}
+	fmt.Fprintf(w, "%d projects, %d failed\n", len(results), failed)
+	return failed
+}
+
+// cleanupRecursive cleans all the projects under root in parallel and prints
+// a combined report. It returns the number of failed projects.
+func cleanupRecursive(w io.Writer, root string) (int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return 0, err
+	}
+	projects, err := findProjects(root)
+	if err != nil {
+		return 0, err
+	}
+	if len(projects) == 0 {
+		return 0, fmt.Errorf("cannot find any %s under %s", gpath.GlideFile, root)
+	}
+
+	jobs := runtime.NumCPU()
+	// The projects may share the same git index
+	if opts.gitStage || opts.gitCommit != "" {
+		jobs = 1
+	}
+	return printResults(w, root, cleanupProjects(projects, jobs)), nil
+}
+
+func relPath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
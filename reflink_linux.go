@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// ficlone returns the FICLONE ioctl request, _IOW(0x94, 9, int). Its write
+// direction bit depends on the architecture.
+func ficlone() uintptr {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc", "ppc64", "ppc64le", "sparc64":
+		return 0x80049409
+	}
+	return 0x40049409
+}
+
+// reflink creates dst as a copy on write clone of src. It works only on the
+// file systems supporting it (like btrfs and xfs).
+func reflink(src, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fi, err := s.Stat()
+	if err != nil {
+		return err
+	}
+
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.Fd(), ficlone(), s.Fd()); errno != 0 {
+		d.Close()
+		os.Remove(dst)
+		return errno
+	}
+	return d.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// reflink isn't supported on this platform
+func reflink(src, dst string) error {
+	return errors.New("reflinks not supported")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Report formats
+const (
+	formatList = "list"
+	formatTree = "tree"
+	formatDiff = "diff"
+)
+
+// reportEntry is a file or directory inside the vendor dir
+type reportEntry struct {
+	// path relative to the vendor dir
+	path    string
+	isDir   bool
+	size    int64
+	removed bool
+	// symlinks and their targets, never followed
+	symlink bool
+	target  string
+}
+
+// vendorEntries returns all the entries inside the vendor dir, also the ones
+// inside removed directories, in lexical (walk) order. Symlinks are reported
+// as entries without reading their targets.
+func vendorEntries(searchPath string, isKept func(localPath string) bool) ([]reportEntry, error) {
+	var entries []reportEntry
+	err := filepath.Walk(searchPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		localPath := strings.TrimPrefix(path, searchPath)
+		if localPath == "" || path+string(os.PathSeparator) == searchPath {
+			return nil
+		}
+		e := reportEntry{
+			path:    localPath,
+			isDir:   info.IsDir(),
+			size:    info.Size(),
+			removed: !isKept(localPath),
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			e.symlink = true
+			if e.target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// treeNode is a node of the rendered vendor tree
+type treeNode struct {
+	name     string
+	entry    reportEntry
+	children []*treeNode
+}
+
+// printTree prints the vendor tree marking the removed entries. Directories
+// deeper than depth (if greater than 0) are collapsed to a summary line.
+func printTree(w io.Writer, root string, entries []reportEntry, depth int) {
+	rootNode := &treeNode{name: root, entry: reportEntry{isDir: true}}
+	nodes := map[string]*treeNode{"": rootNode}
+	// entries are in walk order so parents always come before their children
+	for _, e := range entries {
+		parent := filepath.Dir(e.path)
+		if parent == "." {
+			parent = ""
+		}
+		node := &treeNode{name: filepath.Base(e.path), entry: e}
+		nodes[e.path] = node
+		if p, ok := nodes[parent]; ok {
+			p.children = append(p.children, node)
+		}
+	}
+
+	fmt.Fprintln(w, root)
+	printTreeNodes(w, rootNode.children, "", 1, depth)
+}
+
+func printTreeNodes(w io.Writer, nodes []*treeNode, prefix string, level, depth int) {
+	for i, node := range nodes {
+		connector, childPrefix := "├── ", "│   "
+		if i == len(nodes)-1 {
+			connector, childPrefix = "└── ", "    "
+		}
+		name := node.name
+		switch {
+		case node.entry.isDir:
+			name += "/"
+		case node.entry.symlink:
+			name += " -> " + node.entry.target
+		}
+		collapse := node.entry.isDir && depth > 0 && level >= depth && len(node.children) > 0
+		switch {
+		case collapse:
+			files, removed := countFiles(node)
+			if node.entry.removed {
+				name += " [removed]"
+			}
+			fmt.Fprintf(w, "%s%s%s (%d files, %d removed)\n", prefix, connector, name, files, removed)
+		case node.entry.removed:
+			fmt.Fprintf(w, "%s%s%s [removed]\n", prefix, connector, name)
+		default:
+			fmt.Fprintf(w, "%s%s%s\n", prefix, connector, name)
+		}
+		if !collapse {
+			printTreeNodes(w, node.children, prefix+childPrefix, level+1, depth)
+		}
+	}
+}
+
+// countFiles returns the number of files and removed files under a node
+func countFiles(node *treeNode) (int, int) {
+	var files, removed int
+	for _, child := range node.children {
+		if child.entry.isDir {
+			f, r := countFiles(child)
+			files += f
+			removed += r
+			continue
+		}
+		files++
+		if child.entry.removed {
+			removed++
+		}
+	}
+	return files, removed
+}
+
+// printDiffStat prints the removed files like git diff --stat does. Like git,
+// a symlink counts as a one line file containing its target.
+func printDiffStat(w io.Writer, vpath string, entries []reportEntry) error {
+	const maxGraphWidth = 40
+
+	type stat struct {
+		name   string
+		lines  int
+		binary bool
+		size   int64
+	}
+	var (
+		stats    []stat
+		maxLines int
+		maxName  int
+		total    int
+	)
+	for _, e := range entries {
+		if e.isDir || !e.removed {
+			continue
+		}
+		var data []byte
+		if e.symlink {
+			data = []byte(e.target)
+		} else {
+			var err error
+			if data, err = ioutil.ReadFile(filepath.Join(vpath, e.path)); err != nil {
+				return err
+			}
+		}
+		s := stat{name: filepath.ToSlash(filepath.Join(filepath.Base(vpath), e.path)), size: e.size}
+		if bytes.IndexByte(data, 0) >= 0 {
+			s.binary = true
+		} else {
+			s.lines = bytes.Count(data, []byte("\n"))
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				s.lines++
+			}
+		}
+		if s.lines > maxLines {
+			maxLines = s.lines
+		}
+		if len(s.name) > maxName {
+			maxName = len(s.name)
+		}
+		total += s.lines
+		stats = append(stats, s)
+	}
+
+	for _, s := range stats {
+		if s.binary {
+			fmt.Fprintf(w, " %-*s | Bin %d -> 0 bytes\n", maxName, s.name, s.size)
+			continue
+		}
+		width := s.lines
+		if maxLines > maxGraphWidth {
+			width = (s.lines*maxGraphWidth + maxLines - 1) / maxLines
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf(" %-*s | %d %s", maxName, s.name, s.lines, strings.Repeat("-", width)), " "))
+	}
+	fmt.Fprintln(w, diffStatSummary(len(stats), total))
+	return nil
+}
+
+// diffStatSummary returns the git diff --stat summary line of the removed
+// files. Like git, the insertions are omitted when there are deletions and
+// both are omitted when no file changed.
+func diffStatSummary(files, deletions int) string {
+	if files == 0 {
+		return " 0 files changed"
+	}
+	summary := fmt.Sprintf(" %d %s changed", files, plural(files, "file", "files"))
+	if deletions == 0 {
+		summary += ", 0 insertions(+)"
+	}
+	return summary + fmt.Sprintf(", %d %s(-)", deletions, plural(deletions, "deletion", "deletions"))
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
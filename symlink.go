@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gpath "github.com/Masterminds/glide/path"
+)
+
+// walkVendor walks the vendor dir like filepath.Walk reporting the symlinks
+// found. When follow is true the symlinks to directories are walked like
+// directories, except the ones creating a cycle. vpath must not contain
+// symlinks.
+func walkVendor(w io.Writer, vpath string, follow bool, walkFn filepath.WalkFunc) error {
+	info, err := os.Lstat(vpath)
+	if err != nil {
+		return walkFn(vpath, nil, err)
+	}
+	err = walkVendorPath(w, vpath, vpath, info, follow, nil, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkVendorPath walks path. ancestors are the real paths of the directories
+// containing path, used to detect the symlink cycles.
+func walkVendorPath(w io.Writer, vpath, path string, info os.FileInfo, follow bool, ancestors []string, walkFn filepath.WalkFunc) error {
+	if gpath.IsLink(info) {
+		if fi := inspectSymlink(w, vpath, path, follow, ancestors); fi != nil {
+			info = fi
+		}
+	}
+
+	err := walkFn(path, info, nil)
+	if err != nil || !info.IsDir() {
+		return err
+	}
+
+	if follow {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return walkFn(path, info, err)
+		}
+		ancestors = append(ancestors, realPath)
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err != nil {
+			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		err = walkVendorPath(w, vpath, filename, fileInfo, follow, ancestors, walkFn)
+		if err != nil && (!fileInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// inspectSymlink reports a symlink inside the vendor dir. When follow is true
+// and the symlink points to a directory that doesn't create a cycle it
+// returns the directory info.
+func inspectSymlink(w io.Writer, vpath, path string, follow bool, ancestors []string) os.FileInfo {
+	localPath := strings.TrimPrefix(path, vpath+string(os.PathSeparator))
+	target, _ := os.Readlink(path)
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		fmt.Fprintf(w, "Found broken symlink: %s -> %s\n", localPath, target)
+		return nil
+	}
+	where := ""
+	if !isParentDirectory(vpath, realPath) {
+		where = " (outside the vendor dir)"
+	}
+
+	fi, err := os.Stat(realPath)
+	if err != nil || !follow || !fi.IsDir() {
+		fmt.Fprintf(w, "Found symlink: %s -> %s%s\n", localPath, target, where)
+		return nil
+	}
+	for _, dir := range ancestors {
+		if isParentDirectory(realPath, dir) {
+			fmt.Fprintf(w, "Not following symlink cycle: %s -> %s\n", localPath, target)
+			return nil
+		}
+	}
+	fmt.Fprintf(w, "Following symlink: %s -> %s%s\n", localPath, target, where)
+	return fi
+}
+
+// checkRemovable verifies that removing path will only remove files inside
+// the vendor dir: its parent directories must not be symlinks pointing
+// outside it. The removal of a symlink only removes the symlink.
+func checkRemovable(vpath, path string) error {
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if !isParentDirectory(vpath, parent) {
+		return fmt.Errorf("refusing to remove %s: it's outside the vendor dir %s", path, vpath)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	gpath "github.com/Masterminds/glide/path"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [project dir]",
+	Short: "watch glide.lock and the vendor dir and clean the vendor dir after every change",
+	Run:   watch,
+}
+
+var watchSettle time.Duration
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", 2*time.Second, "time to wait without changes before cleaning the vendor dir")
+	cmd.AddCommand(watchCmd)
+}
+
+func watch(cmd *cobra.Command, args []string) {
+	if err := validateOptions(); err != nil {
+		fatal(err)
+	}
+
+	root, err := projectRoot(args)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := runWatch(root); err != nil {
+		fatal(err)
+	}
+}
+
+func runWatch(path string) error {
+	vpath, err := vendorPath(path)
+	if err != nil {
+		return err
+	}
+	lockPath := filepath.Join(path, gpath.LockFile)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Watch the project dir (to detect glide.lock rewrites) and all the
+	// vendor dirs
+	if err := watcher.Add(path); err != nil {
+		return err
+	}
+	if err := watchDirs(watcher, vpath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout(), "Watching %s and %s\n", lockPath, vpath)
+
+	events := make(chan fsnotify.Event)
+	go func() {
+		defer close(events)
+		for ev := range watcher.Events {
+			// Watch the new directories inside vendor
+			if ev.Op&fsnotify.Create != 0 && isParentDirectory(vpath, ev.Name) {
+				if err := watchDirs(watcher, ev.Name); err != nil && !os.IsNotExist(err) {
+					logger.err(err.Error())
+				}
+			}
+			if ev.Name == lockPath || isParentDirectory(vpath, ev.Name) {
+				events <- ev
+			}
+		}
+	}()
+
+	return watchLoop(events, watcher.Errors, watchSettle, func() error {
+		return watchCleanup(path, vpath)
+	})
+}
+
+// watchLoop calls run when no events are received for the settle time after
+// the last event. The events received while run is executing (like the ones
+// caused by the files removal) are discarded.
+func watchLoop(events <-chan fsnotify.Event, errors <-chan error, settle time.Duration, run func() error) error {
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			timer = time.After(settle)
+		case err, ok := <-errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-timer:
+			timer = nil
+			if err := run(); err != nil {
+				logger.err(err.Error())
+			}
+			// Discard the events generated by run
+			for drained := false; !drained; {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						return nil
+					}
+				case <-time.After(100 * time.Millisecond):
+					drained = true
+				}
+			}
+		}
+	}
+}
+
+// watchCleanup cleans the vendor dir and prints a summary.
+func watchCleanup(path, vpath string) error {
+	files, size, err := diskUsage(vpath)
+	if err != nil {
+		return err
+	}
+	if err := cleanup(stdout(), path); err != nil {
+		return err
+	}
+	newFiles, newSize, err := diskUsage(vpath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout(), "%s: removed %d files, %d bytes saved\n", time.Now().Format("15:04:05"), files-newFiles, size-newSize)
+	return nil
+}
+
+// watchDirs adds a watch for dir and all the directories inside it.
+func watchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}